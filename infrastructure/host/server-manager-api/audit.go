@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Remote string    `json:"remote"`
+	Action string    `json:"action"`
+	Server string    `json:"server"`
+	DryRun bool      `json:"dry_run"`
+	Code   int       `json:"code"`
+	Result string    `json:"result"`
+}
+
+// AuditLog keeps the most recent power requests in memory and mirrors each one to the log.
+type AuditLog struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+	limit   int
+}
+
+func NewAuditLog(limit int) *AuditLog {
+	return &AuditLog{limit: limit}
+}
+
+func (a *AuditLog) Record(entry AuditEntry) {
+	log.Printf("audit: remote=%s action=%q server=%q dry_run=%t code=%d result=%q",
+		entry.Remote, entry.Action, entry.Server, entry.DryRun, entry.Code, entry.Result)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.entries = append(a.entries, entry)
+	if len(a.entries) > a.limit {
+		a.entries = a.entries[len(a.entries)-a.limit:]
+	}
+}
+
+func (a *AuditLog) Entries() []AuditEntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	entries := make([]AuditEntry, len(a.entries))
+	copy(entries, a.entries)
+	return entries
+}
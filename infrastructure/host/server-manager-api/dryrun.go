@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// DryRunManager simulates VirtualBox power state in memory so power requests
+// can be exercised without touching any VM. Every known server starts powered off.
+type DryRunManager struct {
+	mu     sync.Mutex
+	states map[string]string
+}
+
+func NewDryRunManager(servers []string) *DryRunManager {
+	states := make(map[string]string, len(servers))
+	for _, s := range servers {
+		states[s] = "poweroff"
+	}
+	return &DryRunManager{states: states}
+}
+
+func (d *DryRunManager) StartVM(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.states[name] == "running" {
+		return ErrVMAlreadyRunning
+	}
+	d.states[name] = "running"
+	return nil
+}
+
+func (d *DryRunManager) StopVM(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.states[name] != "running" {
+		return fmt.Errorf("machine '%s' is not currently running (simulated)", name)
+	}
+	d.states[name] = "poweroff"
+	return nil
+}
+
+func (d *DryRunManager) ShutdownVM(name string) error {
+	return d.StopVM(name)
+}
+
+func (d *DryRunManager) SnapshotVM(name, snapshot string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.states[name]; !ok {
+		return fmt.Errorf("vm '%s' not known to dry run", name)
+	}
+	return nil
+}
+
+func (d *DryRunManager) VMState(name string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state, ok := d.states[name]
+	if !ok {
+		return "", fmt.Errorf("vm '%s' not known to dry run", name)
+	}
+	return state, nil
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,13 +11,21 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
 )
 
 type Config struct {
 	Servers []string
 	Port    string
+	DryRun  bool
+	Token   string
 }
 
 var ErrVMAlreadyRunning = errors.New("vm already running")
@@ -25,6 +35,9 @@ type VBoxManager struct{}
 type Virtualizer interface {
 	StartVM(name string) error
 	StopVM(name string) error
+	ShutdownVM(name string) error
+	SnapshotVM(name, snapshot string) error
+	VMState(name string) (string, error)
 }
 
 type PowerRequest struct {
@@ -32,10 +45,28 @@ type PowerRequest struct {
 	Server string `json:"server"`
 }
 
+type SnapshotRequest struct {
+	Server string `json:"server"`
+	Name   string `json:"name"`
+}
+
 type Response struct {
 	Status  string `json:"status,omitempty"`
 	Error   string `json:"error,omitempty"`
 	Service string `json:"service,omitempty"`
+	DryRun  bool   `json:"dry_run,omitempty"`
+	Command string `json:"command,omitempty"`
+}
+
+type ServerStatus struct {
+	Server string `json:"server"`
+	State  string `json:"state"`
+	Error  string `json:"error,omitempty"`
+}
+
+type StatusResponse struct {
+	DryRun  bool           `json:"dry_run"`
+	Servers []ServerStatus `json:"servers"`
 }
 
 func LoadConfig() *Config {
@@ -58,11 +89,41 @@ func LoadConfig() *Config {
 	return &Config{
 		Servers: servers,
 		Port:    port,
+		DryRun:  os.Getenv("DRY_RUN") == "true",
+		Token:   os.Getenv("API_TOKEN"),
+	}
+}
+
+func (c *Config) IsAllowed(server string) bool {
+	for _, s := range c.Servers {
+		if s == server {
+			return true
+		}
+	}
+	return false
+}
+
+// vboxCommand builds the VBoxManage invocation for args. Tests replace it to
+// assert that the dry run never reaches VirtualBox.
+var vboxCommand = func(args ...string) *exec.Cmd {
+	return exec.Command("VBoxManage", args...)
+}
+
+// vboxArgs returns the VBoxManage arguments used to perform action on the named VM.
+func vboxArgs(action, name string) []string {
+	switch action {
+	case "on":
+		return []string{"startvm", name, "--type", "headless"}
+	case "off":
+		return []string{"controlvm", name, "poweroff"}
+	case "shutdown":
+		return []string{"controlvm", name, "acpipowerbutton"}
 	}
+	return nil
 }
 
 func (v *VBoxManager) StartVM(name string) error {
-	cmd := exec.Command("VBoxManage", "startvm", name, "--type", "headless")
+	cmd := vboxCommand(vboxArgs("on", name)...)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		if strings.Contains(string(output), "already locked by a session") {
@@ -74,15 +135,98 @@ func (v *VBoxManager) StartVM(name string) error {
 }
 
 func (v *VBoxManager) StopVM(name string) error {
-	cmd := exec.Command("VBoxManage", "controlvm", name, "poweroff")
+	cmd := vboxCommand(vboxArgs("off", name)...)
 	return cmd.Run()
 }
 
+func (v *VBoxManager) ShutdownVM(name string) error {
+	cmd := vboxCommand(vboxArgs("shutdown", name)...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to shut down vm: %v, output: %s", err, string(output))
+	}
+	return nil
+}
+
+func (v *VBoxManager) SnapshotVM(name, snapshot string) error {
+	cmd := vboxCommand("snapshot", name, "take", snapshot)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to take snapshot: %v, output: %s", err, string(output))
+	}
+	return nil
+}
+
+func (v *VBoxManager) VMState(name string) (string, error) {
+	cmd := vboxCommand("showvminfo", name, "--machinereadable")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to query vm: %v, output: %s", err, string(output))
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		if state, ok := strings.CutPrefix(line, "VMState="); ok {
+			return strings.Trim(strings.TrimSpace(state), `"`), nil
+		}
+	}
+	return "", fmt.Errorf("vm state not reported for '%s'", name)
+}
+
+var (
+	tracer = otel.Tracer("server-manager-api")
+	meter  = otel.Meter("server-manager-api")
+
+	// Instruments created before setupOTel installs the meter provider are
+	// forwarded to it by the global delegate.
+	actionCounter, _ = meter.Int64Counter("server_manager.actions",
+		metric.WithDescription("Audited power and snapshot requests by action and response code."))
+)
+
 func main() {
 	config := LoadConfig()
-	virtualizer := &VBoxManager{}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	shutdownOTel, err := setupOTel(context.Background(), "server-manager-api")
+	if err != nil {
+		log.Fatalf("Error setting up OpenTelemetry: %v", err)
+	}
+	shutdownOnSignal(shutdownOTel)
+
+	virtualizer := newVirtualizer(config)
+	if config.DryRun {
+		log.Println("DRY_RUN enabled: power requests are simulated, no VMs will be touched")
+	}
+	mux := newMux(config, virtualizer, NewAuditLog(500))
+
+	if config.Token == "" {
+		log.Println("Warning: API_TOKEN not set, /api/v1 endpoints are unauthenticated")
+	}
+
+	log.Printf("Server starting on port %s...", config.Port)
+	handler := otelhttp.NewHandler(mux, "server-manager-api",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+	)
+	if err := http.ListenAndServe(":"+config.Port, handler); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newVirtualizer returns the in-memory DryRunManager when DRY_RUN is set and
+// the VBoxManage backed manager otherwise.
+func newVirtualizer(config *Config) Virtualizer {
+	if config.DryRun {
+		return NewDryRunManager(config.Servers)
+	}
+	return &VBoxManager{}
+}
+
+// newMux registers the API handlers, performing power and snapshot requests
+// through virtualizer and recording them in audit.
+func newMux(config *Config, virtualizer Virtualizer, audit *AuditLog) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
@@ -90,67 +234,221 @@ func main() {
 		jsonResponse(w, http.StatusOK, Response{
 			Service: "server-manager-api",
 			Status:  "running",
+			DryRun:  config.DryRun,
 		})
 	})
 
-	http.HandleFunc("/api/v1/servers/power", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/api/v1/servers/power", requireToken(config.Token, func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 
+		entry := &AuditEntry{}
+		respond := auditedResponder(w, r, audit, config.DryRun, entry)
+
 		var req PowerRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			jsonResponse(w, http.StatusBadRequest, Response{Error: "Invalid JSON"})
+			respond(http.StatusBadRequest, Response{Error: "Invalid JSON"})
 			return
 		}
+		entry.Action = req.Action
+		entry.Server = req.Server
 
-		if req.Action != "on" && req.Action != "off" {
-			jsonResponse(w, http.StatusBadRequest, Response{Error: "Invalid action. Use 'on' or 'off'."})
+		if req.Action != "on" && req.Action != "off" && req.Action != "shutdown" {
+			respond(http.StatusBadRequest, Response{Error: "Invalid action. Use 'on', 'off' or 'shutdown'."})
 			return
 		}
 
 		if req.Server == "" {
-			jsonResponse(w, http.StatusBadRequest, Response{Error: "Missing 'server' field."})
+			respond(http.StatusBadRequest, Response{Error: "Missing 'server' field."})
 			return
 		}
 
-		allowed := false
-		for _, s := range config.Servers {
-			if s == req.Server {
-				allowed = true
-				break
-			}
+		if !config.IsAllowed(req.Server) {
+			respond(http.StatusNotFound, Response{Error: fmt.Sprintf("Unknown server '%s'.", req.Server)})
+			return
 		}
 
-		if !allowed {
-			jsonResponse(w, http.StatusNotFound, Response{Error: fmt.Sprintf("Unknown server '%s'.", req.Server)})
-			return
+		command := ""
+		if config.DryRun {
+			command = "VBoxManage " + strings.Join(vboxArgs(req.Action, req.Server), " ")
 		}
 
+		_, span := tracer.Start(r.Context(), "vbox."+req.Action)
+		span.SetAttributes(attribute.String("server", req.Server), attribute.Bool("dry_run", config.DryRun))
+
 		var err error
-		if req.Action == "on" {
+		switch req.Action {
+		case "on":
 			err = virtualizer.StartVM(req.Server)
-		} else {
+		case "off":
 			err = virtualizer.StopVM(req.Server)
+		case "shutdown":
+			err = virtualizer.ShutdownVM(req.Server)
+		}
+		if err != nil && err != ErrVMAlreadyRunning {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
 		}
+		span.End()
 
 		if err != nil {
 			if err == ErrVMAlreadyRunning {
-				jsonResponse(w, http.StatusOK, Response{Status: fmt.Sprintf("Server '%s' was already on.", req.Server)})
+				respond(http.StatusOK, Response{Status: fmt.Sprintf("Server '%s' was already on.", req.Server)})
 				return
 			}
 			errMsg := fmt.Sprintf("Failed to perform %s on '%s': %v", req.Action, req.Server, err)
-			jsonResponse(w, http.StatusInternalServerError, Response{Error: errMsg})
+			respond(http.StatusInternalServerError, Response{Error: errMsg})
 			return
 		}
 
-		jsonResponse(w, http.StatusOK, Response{Status: fmt.Sprintf("Server '%s' turned %s successfully.", req.Server, req.Action)})
-	})
+		if config.DryRun {
+			respond(http.StatusOK, Response{
+				Status:  fmt.Sprintf("Dry run: server '%s' would be %s.", req.Server, dryRunOutcome(req.Action)),
+				Command: command,
+			})
+			return
+		}
 
-	log.Printf("Server starting on port %s...", config.Port)
-	if err := http.ListenAndServe(":"+config.Port, nil); err != nil {
-		log.Fatal(err)
+		if req.Action == "shutdown" {
+			respond(http.StatusOK, Response{Status: fmt.Sprintf("Server '%s' signalled to shut down.", req.Server)})
+			return
+		}
+
+		respond(http.StatusOK, Response{Status: fmt.Sprintf("Server '%s' turned %s successfully.", req.Server, req.Action)})
+	}))
+
+	mux.HandleFunc("/api/v1/servers/snapshot", requireToken(config.Token, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		entry := &AuditEntry{Action: "snapshot"}
+		respond := auditedResponder(w, r, audit, config.DryRun, entry)
+
+		var req SnapshotRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			respond(http.StatusBadRequest, Response{Error: "Invalid JSON"})
+			return
+		}
+		entry.Server = req.Server
+
+		if req.Server == "" || req.Name == "" {
+			respond(http.StatusBadRequest, Response{Error: "Missing 'server' or 'name' field."})
+			return
+		}
+
+		if !config.IsAllowed(req.Server) {
+			respond(http.StatusNotFound, Response{Error: fmt.Sprintf("Unknown server '%s'.", req.Server)})
+			return
+		}
+
+		if err := virtualizer.SnapshotVM(req.Server, req.Name); err != nil {
+			errMsg := fmt.Sprintf("Failed to snapshot '%s': %v", req.Server, err)
+			respond(http.StatusInternalServerError, Response{Error: errMsg})
+			return
+		}
+
+		if config.DryRun {
+			respond(http.StatusOK, Response{
+				Status:  fmt.Sprintf("Dry run: snapshot '%s' of server '%s' would be taken.", req.Name, req.Server),
+				Command: fmt.Sprintf("VBoxManage snapshot %s take %s", req.Server, req.Name),
+			})
+			return
+		}
+
+		respond(http.StatusOK, Response{Status: fmt.Sprintf("Snapshot '%s' of server '%s' taken successfully.", req.Name, req.Server)})
+	}))
+
+	mux.HandleFunc("/api/v1/servers/status", requireToken(config.Token, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		servers := config.Servers
+		if name := r.URL.Query().Get("server"); name != "" {
+			if !config.IsAllowed(name) {
+				jsonResponse(w, http.StatusNotFound, Response{Error: fmt.Sprintf("Unknown server '%s'.", name)})
+				return
+			}
+			servers = []string{name}
+		}
+
+		response := StatusResponse{DryRun: config.DryRun, Servers: []ServerStatus{}}
+		for _, name := range servers {
+			status := ServerStatus{Server: name}
+			state, err := virtualizer.VMState(name)
+			if err != nil {
+				status.State = "unknown"
+				status.Error = err.Error()
+			} else {
+				status.State = state
+			}
+			response.Servers = append(response.Servers, status)
+		}
+
+		jsonResponse(w, http.StatusOK, response)
+	}))
+
+	mux.HandleFunc("/api/v1/audit", requireToken(config.Token, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		jsonResponse(w, http.StatusOK, audit.Entries())
+	}))
+
+	return mux
+}
+
+// auditedResponder returns a function that records entry in the audit log with the
+// outcome of the request before writing the JSON response.
+func auditedResponder(w http.ResponseWriter, r *http.Request, audit *AuditLog, dryRun bool, entry *AuditEntry) func(int, Response) {
+	entry.Time = time.Now().UTC()
+	entry.Remote = r.RemoteAddr
+	entry.DryRun = dryRun
+
+	return func(code int, resp Response) {
+		entry.Code = code
+		entry.Result = resp.Status
+		if resp.Error != "" {
+			entry.Result = resp.Error
+		}
+		audit.Record(*entry)
+		actionCounter.Add(r.Context(), 1, metric.WithAttributes(
+			attribute.String("action", entry.Action),
+			attribute.String("server", entry.Server),
+			attribute.Int("code", code),
+			attribute.Bool("dry_run", dryRun),
+		))
+		resp.DryRun = dryRun
+		jsonResponse(w, code, resp)
+	}
+}
+
+// dryRunOutcome describes what a power action would have done to a server.
+func dryRunOutcome(action string) string {
+	if action == "shutdown" {
+		return "shut down"
+	}
+	return "turned " + action
+}
+
+// requireToken rejects requests that do not carry "Authorization: Bearer <token>".
+// An empty token disables the check.
+func requireToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				jsonResponse(w, http.StatusUnauthorized, Response{Error: "Unauthorized"})
+				return
+			}
+		}
+		next(w, r)
 	}
 }
 
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestDryRunNeverCallsVBoxManage(t *testing.T) {
+	orig := vboxCommand
+	t.Cleanup(func() { vboxCommand = orig })
+	vboxCommand = func(args ...string) *exec.Cmd {
+		t.Errorf("VBoxManage %s called during a dry run", strings.Join(args, " "))
+		return exec.Command("false")
+	}
+
+	config := &Config{Servers: []string{"gandalf"}, DryRun: true}
+	virtualizer := newVirtualizer(config)
+	if _, ok := virtualizer.(*DryRunManager); !ok {
+		t.Fatalf("newVirtualizer() = %T with DRY_RUN set, want *DryRunManager", virtualizer)
+	}
+	srv := httptest.NewServer(newMux(config, virtualizer, NewAuditLog(10)))
+	defer srv.Close()
+
+	tests := []struct {
+		path       string
+		body       string
+		wantCode   int
+		wantStatus string
+	}{
+		{"/api/v1/servers/power", `{"action": "on", "server": "gandalf"}`, http.StatusOK, "Dry run: server 'gandalf' would be turned on."},
+		{"/api/v1/servers/power", `{"action": "on", "server": "gandalf"}`, http.StatusOK, "Server 'gandalf' was already on."},
+		{"/api/v1/servers/power", `{"action": "shutdown", "server": "gandalf"}`, http.StatusOK, "Dry run: server 'gandalf' would be shut down."},
+		{"/api/v1/servers/power", `{"action": "on", "server": "gandalf"}`, http.StatusOK, "Dry run: server 'gandalf' would be turned on."},
+		{"/api/v1/servers/power", `{"action": "off", "server": "gandalf"}`, http.StatusOK, "Dry run: server 'gandalf' would be turned off."},
+		{"/api/v1/servers/power", `{"action": "off", "server": "gandalf"}`, http.StatusInternalServerError, ""},
+		{"/api/v1/servers/snapshot", `{"server": "gandalf", "name": "before-upgrade"}`, http.StatusOK, "Dry run: snapshot 'before-upgrade' of server 'gandalf' would be taken."},
+	}
+	for _, tt := range tests {
+		resp, err := http.Post(srv.URL+tt.path, "application/json", bytes.NewBufferString(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Response
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("POST %s %s: decoding response: %v", tt.path, tt.body, err)
+		}
+		if resp.StatusCode != tt.wantCode || got.Status != tt.wantStatus || !got.DryRun {
+			t.Errorf("POST %s %s = %d %+v, want %d with status %q", tt.path, tt.body, resp.StatusCode, got, tt.wantCode, tt.wantStatus)
+		}
+	}
+
+	resp, err := http.Get(srv.URL + "/api/v1/servers/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var status StatusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if !status.DryRun || len(status.Servers) != 1 || status.Servers[0].State != "poweroff" {
+		t.Errorf("status = %+v, want gandalf powered off in a dry run", status)
+	}
+}
+
+func TestDryRunOutcome(t *testing.T) {
+	tests := []struct {
+		action string
+		want   string
+	}{
+		{"on", "turned on"},
+		{"off", "turned off"},
+		{"shutdown", "shut down"},
+	}
+	for _, tt := range tests {
+		if got := dryRunOutcome(tt.action); got != tt.want {
+			t.Errorf("dryRunOutcome(%q) = %q, want %q", tt.action, got, tt.want)
+		}
+	}
+}
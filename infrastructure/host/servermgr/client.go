@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type Response struct {
+	Status  string `json:"status,omitempty"`
+	Error   string `json:"error,omitempty"`
+	DryRun  bool   `json:"dry_run,omitempty"`
+	Command string `json:"command,omitempty"`
+}
+
+type ServerStatus struct {
+	Server string `json:"server"`
+	State  string `json:"state"`
+	Error  string `json:"error,omitempty"`
+}
+
+type StatusResponse struct {
+	DryRun  bool           `json:"dry_run"`
+	Servers []ServerStatus `json:"servers"`
+}
+
+type AuditEntry struct {
+	Time   time.Time `json:"time"`
+	Remote string    `json:"remote"`
+	Action string    `json:"action"`
+	Server string    `json:"server"`
+	DryRun bool      `json:"dry_run"`
+	Code   int       `json:"code"`
+	Result string    `json:"result"`
+}
+
+// APIError is returned when the server manager answers with a non-2xx status.
+type APIError struct {
+	Code    int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("server manager returned %d: %s", e.Code, e.Message)
+}
+
+type Client struct {
+	BaseURL string
+	Token   string
+	HTTP    *http.Client
+}
+
+func NewClient(baseURL, token string, timeout time.Duration) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Token:   token,
+		HTTP:    &http.Client{Timeout: timeout},
+	}
+}
+
+func (c *Client) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiResp Response
+		if json.Unmarshal(data, &apiResp) == nil && apiResp.Error != "" {
+			return &APIError{Code: resp.StatusCode, Message: apiResp.Error}
+		}
+		return &APIError{Code: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("invalid response from server manager: %v", err)
+		}
+	}
+	return nil
+}
+
+func (c *Client) Status(server string) (*StatusResponse, error) {
+	path := "/api/v1/servers/status"
+	if server != "" {
+		path += "?server=" + url.QueryEscape(server)
+	}
+	var status StatusResponse
+	if err := c.do(http.MethodGet, path, nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+func (c *Client) Power(server, action string) (*Response, error) {
+	var resp Response
+	body := map[string]string{"action": action, "server": server}
+	if err := c.do(http.MethodPost, "/api/v1/servers/power", body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) Snapshot(server, name string) (*Response, error) {
+	var resp Response
+	body := map[string]string{"server": server, "name": name}
+	if err := c.do(http.MethodPost, "/api/v1/servers/snapshot", body, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) Audit() ([]AuditEntry, error) {
+	var entries []AuditEntry
+	if err := c.do(http.MethodGet, "/api/v1/audit", nil, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
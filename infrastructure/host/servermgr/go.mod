@@ -0,0 +1,3 @@
+module servermgr
+
+go 1.25.4
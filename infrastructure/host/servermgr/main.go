@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// Exit codes reported by servermgr.
+const (
+	exitOK      = 0
+	exitFailed  = 1
+	exitUsage   = 2
+	exitTimeout = 3
+)
+
+const usage = `Usage: servermgr [flags] <command> [args]
+
+Commands:
+  list                           List all servers and their power state
+  status <server>                Show the power state of one server
+  on <server>                    Power a server on
+  off <server>                   Power a server off (hard power-off)
+  shutdown <server>              Send an ACPI shutdown signal to a server
+  wait <server> [flags]          Wait until a server reaches a power state
+  snapshot <server> <name>       Take a VirtualBox snapshot of a server
+  audit [flags]                  Show recent power requests
+
+Flags:
+`
+
+var errUsage = errors.New("usage error")
+
+type cli struct {
+	client *Client
+	json   bool
+	out    io.Writer
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("servermgr", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	apiURL := flags.String("api", envOr("SERVERMGR_API", "http://localhost:3000"), "server manager API base URL (env SERVERMGR_API)")
+	token := flags.String("token", os.Getenv("SERVERMGR_TOKEN"), "API bearer token (env SERVERMGR_TOKEN)")
+	jsonOut := flags.Bool("json", false, "print JSON instead of tables")
+	timeout := flags.Duration("timeout", 30*time.Second, "HTTP request timeout")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	c := &cli{
+		client: NewClient(*apiURL, *token, *timeout),
+		json:   *jsonOut,
+		out:    stdout,
+	}
+
+	command, rest := flags.Arg(0), flags.Args()[1:]
+	var err error
+	switch command {
+	case "list":
+		err = c.list(rest)
+	case "status":
+		err = c.status(rest)
+	case "on", "off", "shutdown":
+		err = c.power(command, rest)
+	case "wait":
+		err = c.wait(rest, stderr)
+	case "snapshot":
+		err = c.snapshot(rest)
+	case "audit":
+		err = c.audit(rest, stderr)
+	default:
+		fmt.Fprintf(stderr, "servermgr: unknown command %q\n\n", command)
+		flags.Usage()
+		return exitUsage
+	}
+
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errUsage):
+		fmt.Fprintln(stderr, "servermgr:", err)
+		return exitUsage
+	case errors.Is(err, errWaitTimeout):
+		fmt.Fprintln(stderr, "servermgr:", err)
+		return exitTimeout
+	default:
+		fmt.Fprintln(stderr, "servermgr:", err)
+		return exitFailed
+	}
+}
+
+func (c *cli) list(args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("%w: list takes no arguments", errUsage)
+	}
+	status, err := c.client.Status("")
+	if err != nil {
+		return err
+	}
+	return c.printStatus(status)
+}
+
+func (c *cli) status(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%w: status requires exactly one server", errUsage)
+	}
+	status, err := c.client.Status(args[0])
+	if err != nil {
+		return err
+	}
+	return c.printStatus(status)
+}
+
+func (c *cli) power(action string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%w: %s requires exactly one server", errUsage, action)
+	}
+	resp, err := c.client.Power(args[0], action)
+	if err != nil {
+		return err
+	}
+	return c.printResponse(resp)
+}
+
+var errWaitTimeout = errors.New("timed out waiting for server state")
+
+func (c *cli) wait(args []string, stderr io.Writer) error {
+	flags := flag.NewFlagSet("wait", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	state := flags.String("state", "running", "power state to wait for (e.g. running, poweroff)")
+	timeout := flags.Duration("for", 2*time.Minute, "maximum time to wait")
+	interval := flags.Duration("interval", 2*time.Second, "polling interval")
+
+	server, rest := splitServerArg(args)
+	if err := flags.Parse(rest); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if server == "" && flags.NArg() == 1 {
+		server = flags.Arg(0)
+	} else if server == "" || flags.NArg() != 0 {
+		return fmt.Errorf("%w: wait requires exactly one server", errUsage)
+	}
+
+	deadline := time.Now().Add(*timeout)
+	for {
+		status, err := c.client.Status(server)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			return err
+		}
+		if err == nil && len(status.Servers) == 1 && status.Servers[0].State == *state {
+			return c.printStatus(status)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w: '%s' did not reach %q within %s", errWaitTimeout, server, *state, *timeout)
+		}
+		time.Sleep(*interval)
+	}
+}
+
+func (c *cli) snapshot(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("%w: snapshot requires a server and a snapshot name", errUsage)
+	}
+	resp, err := c.client.Snapshot(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	return c.printResponse(resp)
+}
+
+func (c *cli) audit(args []string, stderr io.Writer) error {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	limit := flags.Int("limit", 20, "number of most recent entries to show (0 for all)")
+	server := flags.String("server", "", "only show entries for this server")
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+
+	entries, err := c.client.Audit()
+	if err != nil {
+		return err
+	}
+
+	filtered := []AuditEntry{}
+	for _, e := range entries {
+		if *server == "" || e.Server == *server {
+			filtered = append(filtered, e)
+		}
+	}
+	if *limit > 0 && len(filtered) > *limit {
+		filtered = filtered[len(filtered)-*limit:]
+	}
+
+	if c.json {
+		return c.printJSON(filtered)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tREMOTE\tACTION\tSERVER\tDRY RUN\tCODE\tRESULT")
+	for _, e := range filtered {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%t\t%d\t%s\n",
+			e.Time.Local().Format(time.DateTime), e.Remote, e.Action, e.Server, e.DryRun, e.Code, e.Result)
+	}
+	return tw.Flush()
+}
+
+func (c *cli) printStatus(status *StatusResponse) error {
+	if c.json {
+		return c.printJSON(status)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERVER\tSTATE\tERROR")
+	for _, s := range status.Servers {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Server, s.State, s.Error)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if status.DryRun {
+		fmt.Fprintln(c.out, "(server manager is in dry-run mode; states are simulated)")
+	}
+	return nil
+}
+
+func (c *cli) printResponse(resp *Response) error {
+	if c.json {
+		return c.printJSON(resp)
+	}
+	fmt.Fprintln(c.out, resp.Status)
+	if resp.Command != "" {
+		fmt.Fprintln(c.out, "would run:", resp.Command)
+	}
+	return nil
+}
+
+func (c *cli) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// splitServerArg lets the server name come before the subcommand flags,
+// e.g. "wait gandalf --state running".
+func splitServerArg(args []string) (string, []string) {
+	if len(args) > 0 && len(args[0]) > 0 && args[0][0] != '-' {
+		return args[0], args[1:]
+	}
+	return "", args
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
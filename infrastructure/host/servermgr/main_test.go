@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeAPI answers like server-manager-api for a single running server named
+// gandalf and requires the token "secret".
+func fakeAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(Response{Error: "Unauthorized"})
+		return
+	}
+
+	switch r.URL.Path {
+	case "/api/v1/servers/status":
+		if server := r.URL.Query().Get("server"); server != "" && server != "gandalf" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(Response{Error: "Unknown server '" + server + "'."})
+			return
+		}
+		json.NewEncoder(w).Encode(StatusResponse{Servers: []ServerStatus{{Server: "gandalf", State: "running"}}})
+	case "/api/v1/servers/power", "/api/v1/servers/snapshot":
+		json.NewEncoder(w).Encode(Response{Status: "ok"})
+	case "/api/v1/audit":
+		json.NewEncoder(w).Encode([]AuditEntry{})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(fakeAPI))
+	defer srv.Close()
+
+	closed := httptest.NewServer(http.HandlerFunc(fakeAPI))
+	closed.Close()
+
+	api := func(args ...string) []string {
+		return append([]string{"--api", srv.URL, "--token", "secret"}, args...)
+	}
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"help", []string{"-h"}, exitOK},
+		{"list", api("list"), exitOK},
+		{"status", api("status", "gandalf"), exitOK},
+		{"power on", api("on", "gandalf"), exitOK},
+		{"shutdown", api("shutdown", "gandalf"), exitOK},
+		{"snapshot", api("snapshot", "gandalf", "before-upgrade"), exitOK},
+		{"audit", api("audit", "--limit", "5"), exitOK},
+		{"wait reached", api("wait", "gandalf", "--state", "running"), exitOK},
+
+		{"unknown server", api("status", "saruman"), exitFailed},
+		{"unauthorized", []string{"--api", srv.URL, "--token", "wrong", "list"}, exitFailed},
+		{"unreachable", []string{"--api", closed.URL, "list"}, exitFailed},
+		{"wait unknown server", api("wait", "saruman"), exitFailed},
+
+		{"no command", nil, exitUsage},
+		{"unknown flag", []string{"--bogus", "list"}, exitUsage},
+		{"unknown command", api("reboot", "gandalf"), exitUsage},
+		{"list with arguments", api("list", "gandalf"), exitUsage},
+		{"power without server", api("on"), exitUsage},
+		{"snapshot without name", api("snapshot", "gandalf"), exitUsage},
+		{"wait without server", api("wait", "--state", "running"), exitUsage},
+		{"audit bad flag", api("audit", "--limit", "many"), exitUsage},
+
+		{"wait timeout", api("wait", "gandalf", "--state", "poweroff", "--for", "0s", "--interval", "1ms"), exitTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stderr strings.Builder
+			if got := run(tt.args, io.Discard, &stderr); got != tt.want {
+				t.Errorf("run(%q) = %d, want %d; stderr:\n%s", tt.args, got, tt.want, stderr.String())
+			}
+		})
+	}
+}
@@ -1,13 +1,25 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
+	// Embedded so schedule time zones resolve on hosts without tzdata.
+	_ "time/tzdata"
 
 	"scaler/pkg/config"
 	"scaler/pkg/engine"
+	"scaler/pkg/observability"
+	"scaler/pkg/telemetry"
 
 	"github.com/joho/godotenv"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 func main() {
@@ -16,18 +28,127 @@ func main() {
 		log.Printf("Warning: could not load .env file: %v\n", err)
 	}
 
-	cfg := config.LoadConfig()
+	if len(os.Args) > 1 && os.Args[1] == "validate-config" {
+		os.Exit(validateConfig(os.Args[2:]))
+	}
+
+	flags := flag.NewFlagSet("scaler", flag.ExitOnError)
+	configPath := flags.String("config", config.Path(), "YAML or JSON config file; the environment is used when empty")
+	flags.Parse(os.Args[1:])
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+
+	shutdownOTel, err := observability.Setup(context.Background(), "scaler")
+	if err != nil {
+		log.Fatalf("Error setting up OpenTelemetry: %v", err)
+	}
+	observability.ShutdownOnSignal(shutdownOTel)
 
-	if cfg.ServerManagerAPI == "" || len(cfg.AvailableAgents) == 0 {
-		log.Fatal("Server manager api or agents not provided")
+	scalerEngine, err := engine.NewScalerEngine(cfg)
+	if err != nil {
+		log.Fatalf("Error creating scaling engine: %v", err)
 	}
 
-	scalerEngine := engine.NewScalerEngine(cfg)
+	if cfg.IngestAddr != "" {
+		scalerEngine.Telemetry = telemetry.NewStore(cfg.TelemetrySecret, cfg.TelemetryStaleAfter, agentNames(cfg))
 
-	ticker := time.NewTicker(10 * time.Second)
+		mux := statusMux(scalerEngine)
+		mux.Handle("/api/v1/telemetry", scalerEngine.Telemetry)
+		go serve("Telemetry ingest", cfg.IngestAddr, mux)
+	}
+	// Pull mode has no ingest listener, so the status API gets its own.
+	if cfg.StatusAddr != "" && cfg.StatusAddr != cfg.IngestAddr {
+		go serve("Status API", cfg.StatusAddr, statusMux(scalerEngine))
+	}
+
+	ticker := time.NewTicker(cfg.Scaling.Interval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		scalerEngine.EvaluateScaling()
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	for {
+		select {
+		case <-ticker.C:
+			scalerEngine.EvaluateScaling(context.Background())
+		case <-reload:
+			if *configPath == "" {
+				if err := godotenv.Overload(); err != nil {
+					log.Printf("Warning: could not reload .env file: %v\n", err)
+				}
+			}
+			next, err := config.Load(*configPath)
+			if err != nil {
+				log.Printf("Error reloading configuration, keeping the current one: %v", err)
+				continue
+			}
+			if next.IngestAddr != cfg.IngestAddr {
+				log.Printf("Warning: ingest_addr changed from %q to %q; restart the scaler to apply it", cfg.IngestAddr, next.IngestAddr)
+			}
+			if next.StatusAddr != cfg.StatusAddr {
+				log.Printf("Warning: status_addr changed from %q to %q; restart the scaler to apply it", cfg.StatusAddr, next.StatusAddr)
+			}
+			if err := scalerEngine.Reload(next); err != nil {
+				log.Printf("Error reloading configuration, keeping the current one: %v", err)
+				continue
+			}
+			if scalerEngine.Telemetry != nil {
+				scalerEngine.Telemetry.Reconfigure(next.TelemetrySecret, next.TelemetryStaleAfter, agentNames(next))
+			}
+			ticker.Reset(next.Scaling.Interval)
+			cfg = next
+			log.Printf("Configuration reloaded: %d agents, evaluating every %s", len(cfg.AvailableAgents), cfg.Scaling.Interval)
+		}
+	}
+}
+
+// validateConfig implements the validate-config subcommand and returns the
+// process exit code.
+func validateConfig(args []string) int {
+	flags := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	configPath := flags.String("config", config.Path(), "YAML or JSON config file; the environment is used when empty")
+	flags.Parse(args)
+
+	source := *configPath
+	if source == "" {
+		source = "environment"
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", source, err)
+		return 1
+	}
+	fmt.Printf("%s is valid: %d agents, evaluating every %s\n", source, len(cfg.AvailableAgents), cfg.Scaling.Interval)
+	return 0
+}
+
+// statusMux serves the latest evaluation, including each agent's lifecycle
+// state, on /api/v1/status and a liveness check on /health.
+func statusMux(scalerEngine *engine.ScalerEngine) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/status", scalerEngine.ServeStatus)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"service":"scaler","status":"healthy"}`))
+	})
+	return mux
+}
+
+func serve(name, addr string, mux *http.ServeMux) {
+	log.Printf("%s listening on %s", name, addr)
+	if err := http.ListenAndServe(addr, otelhttp.NewHandler(mux, "scaler")); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func agentNames(cfg config.ScalerConfig) []string {
+	var names []string
+	for _, ag := range cfg.AvailableAgents {
+		names = append(names, ag.ServerName)
 	}
+	return names
 }
@@ -1,41 +1,464 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
-	"log"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 type SSHConfig struct {
-	Port string `json:"port"`
-	User string `json:"user"`
-	IP   string `json:"ip"`
+	Port string `json:"port" yaml:"port"`
+	User string `json:"user" yaml:"user"`
+	IP   string `json:"ip" yaml:"ip"`
 }
 
 type AgentConfig struct {
-	ServerName   string    `json:"server_name"`
-	UpstreamURL  string    `json:"upstream_url"`
-	TelemetryURL string    `json:"telemetry_url"`
-	SSH          SSHConfig `json:"ssh"`
+	ServerName   string `json:"server_name" yaml:"server_name"`
+	UpstreamURL  string `json:"upstream_url" yaml:"upstream_url"`
+	TelemetryURL string `json:"telemetry_url" yaml:"telemetry_url"`
+	ReadyURL     string `json:"ready_url,omitempty" yaml:"ready_url,omitempty"`
+	InfoURL      string `json:"info_url,omitempty" yaml:"info_url,omitempty"`
+	Hostname     string `json:"hostname,omitempty" yaml:"hostname,omitempty"`
+	DeployURL    string `json:"deploy_url,omitempty" yaml:"deploy_url,omitempty"`
+	// JobsMetric names the custom metric, as "<collector>.<metric>", that
+	// the agent's metrics API reports its in-flight worker jobs in. The
+	// scaler waits for it to reach zero before powering the agent off.
+	JobsMetric string    `json:"jobs_metric,omitempty" yaml:"jobs_metric,omitempty"`
+	SSH        SSHConfig `json:"ssh" yaml:"ssh"`
+}
+
+// Thresholds are average utilization percentages across the active agents.
+type Thresholds struct {
+	CPU    float64 `json:"cpu" yaml:"cpu"`
+	Memory float64 `json:"memory" yaml:"memory"`
+}
+
+// Scaling policies. When several are configured the engine follows the one
+// that wants the most agents.
+const (
+	// PolicyThreshold adds one agent when average CPU or memory is above
+	// ScaleUp and removes one when both are below ScaleDown.
+	PolicyThreshold = "threshold"
+	// PolicyTarget sizes the cluster so that average utilization stays near
+	// Target.
+	PolicyTarget = "target"
+	// PolicyStep adds or removes as many agents as the Step band the average
+	// falls in asks for.
+	PolicyStep = "step"
+	// PolicyPredictive sizes the cluster for the demand forecast one lead
+	// time ahead.
+	PolicyPredictive = "predictive"
+)
+
+// Forecast models of the predictive policy.
+const (
+	ModelLinear = "linear"
+	ModelHolt   = "holt"
+)
+
+// TargetTracking sets the utilization the target policy aims for. A zero
+// target leaves that metric out. Tolerance is the fraction of the target the
+// average may deviate before the count changes.
+type TargetTracking struct {
+	CPU       float64 `json:"cpu,omitempty" yaml:"cpu,omitempty"`
+	Memory    float64 `json:"memory,omitempty" yaml:"memory,omitempty"`
+	Tolerance float64 `json:"tolerance" yaml:"tolerance"`
+}
+
+// StepBand changes the agent count by Agents when the step metric is at or
+// above Threshold (scale_up bands) or at or below it (scale_down bands).
+type StepBand struct {
+	Threshold float64 `json:"threshold" yaml:"threshold"`
+	Agents    int     `json:"agents" yaml:"agents"`
+}
+
+// StepScaling configures the step policy. When several bands match, the one
+// with the most extreme threshold applies.
+type StepScaling struct {
+	Metric    string     `json:"metric" yaml:"metric"`
+	ScaleUp   []StepBand `json:"scale_up" yaml:"scale_up"`
+	ScaleDown []StepBand `json:"scale_down" yaml:"scale_down"`
+}
+
+// PredictiveScaling configures the predictive policy. Demand is the sum of
+// Metric over the active agents; it is forecast LeadTime ahead from the
+// samples inside Window and divided by Target to get the agent count.
+// LeadTime is only used until the engine has measured how long starting an
+// agent takes. With ForecastOnly the forecast is logged and reported but
+// never acted on.
+type PredictiveScaling struct {
+	Metric       string        `json:"metric" yaml:"metric"`
+	Target       float64       `json:"target" yaml:"target"`
+	Model        string        `json:"model" yaml:"model"`
+	Window       time.Duration `json:"window" yaml:"window"`
+	LeadTime     time.Duration `json:"lead_time" yaml:"lead_time"`
+	ForecastOnly bool          `json:"forecast_only" yaml:"forecast_only"`
+}
+
+type ScalingConfig struct {
+	Interval time.Duration `json:"interval" yaml:"interval"`
+	Policies []string      `json:"policies" yaml:"policies"`
+	// Scale up when average CPU or memory is above ScaleUp, scale down when
+	// both are below ScaleDown.
+	ScaleUp    Thresholds        `json:"scale_up" yaml:"scale_up"`
+	ScaleDown  Thresholds        `json:"scale_down" yaml:"scale_down"`
+	Target     TargetTracking    `json:"target" yaml:"target"`
+	Step       StepScaling       `json:"step" yaml:"step"`
+	Predictive PredictiveScaling `json:"predictive" yaml:"predictive"`
+	// HistoryFile keeps the cluster metrics history across restarts. Empty
+	// keeps it in memory only.
+	HistoryFile string `json:"history_file,omitempty" yaml:"history_file,omitempty"`
+	// MaxStep is the most agents started or stopped in one evaluation.
+	MaxStep int `json:"max_step" yaml:"max_step"`
+	// MinAgents are kept running regardless of load. MaxAgents defaults to
+	// the number of configured agents.
+	MinAgents int `json:"min_agents" yaml:"min_agents"`
+	MaxAgents int `json:"max_agents,omitempty" yaml:"max_agents,omitempty"`
+	// Schedules override MinAgents and MaxAgents during their windows.
+	Schedules []ScheduleRule `json:"schedules,omitempty" yaml:"schedules,omitempty"`
+	// A threshold has to be breached on this many consecutive evaluations
+	// before the engine acts on it.
+	ScaleUpBreaches   int `json:"scale_up_breaches" yaml:"scale_up_breaches"`
+	ScaleDownBreaches int `json:"scale_down_breaches" yaml:"scale_down_breaches"`
+	// Cooldowns are the minimum time between two scale-ups or two
+	// scale-downs. ScaleDownProtection is the minimum time between a scale-up
+	// and the next scale-down.
+	ScaleUpCooldown     time.Duration `json:"scale_up_cooldown" yaml:"scale_up_cooldown"`
+	ScaleDownCooldown   time.Duration `json:"scale_down_cooldown" yaml:"scale_down_cooldown"`
+	ScaleDownProtection time.Duration `json:"scale_down_protection" yaml:"scale_down_protection"`
+	// DrainTimeout is how long an agent that left the upstream may keep
+	// connections and jobs open before it is powered off anyway.
+	DrainTimeout time.Duration `json:"drain_timeout" yaml:"drain_timeout"`
+}
+
+// SSHSettings apply to every agent. User is the default for agents that do not
+// set ssh.user themselves.
+type SSHSettings struct {
+	KeyFile        string        `json:"key_file" yaml:"key_file"`
+	User           string        `json:"user,omitempty" yaml:"user,omitempty"`
+	ConnectTimeout time.Duration `json:"connect_timeout" yaml:"connect_timeout"`
+}
+
+type DeployConfig struct {
+	Repo        string `json:"repo" yaml:"repo"`
+	Ref         string `json:"ref,omitempty" yaml:"ref,omitempty"`
+	ComposePath string `json:"compose_path" yaml:"compose_path"`
+	// Dir is the checkout directory under the agent user's home for SSH deploys.
+	Dir     string        `json:"dir" yaml:"dir"`
+	Token   string        `json:"token,omitempty" yaml:"token,omitempty"`
+	Timeout time.Duration `json:"timeout" yaml:"timeout"`
+	// Env is written to the .env file next to the compose file.
+	Env map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+}
+
+type LoadBalancerConfig struct {
+	UpstreamFile  string   `json:"upstream_file" yaml:"upstream_file"`
+	UpstreamName  string   `json:"upstream_name" yaml:"upstream_name"`
+	ReloadCommand []string `json:"reload_command" yaml:"reload_command"`
 }
 
 type ScalerConfig struct {
-	ServerManagerAPI string
-	AvailableAgents  []AgentConfig
+	ServerManagerAPI    string             `json:"server_manager_api" yaml:"server_manager_api"`
+	ServerManagerToken  string             `json:"server_manager_token,omitempty" yaml:"server_manager_token,omitempty"`
+	AvailableAgents     []AgentConfig      `json:"agents" yaml:"agents"`
+	IngestAddr          string             `json:"ingest_addr,omitempty" yaml:"ingest_addr,omitempty"`
+	StatusAddr          string             `json:"status_addr,omitempty" yaml:"status_addr,omitempty"`
+	TelemetrySecret     string             `json:"telemetry_secret,omitempty" yaml:"telemetry_secret,omitempty"`
+	TelemetryStaleAfter time.Duration      `json:"telemetry_stale_after" yaml:"telemetry_stale_after"`
+	StateFile           string             `json:"state_file,omitempty" yaml:"state_file,omitempty"`
+	Scaling             ScalingConfig      `json:"scaling" yaml:"scaling"`
+	SSH                 SSHSettings        `json:"ssh" yaml:"ssh"`
+	Deploy              DeployConfig       `json:"deploy" yaml:"deploy"`
+	LoadBalancer        LoadBalancerConfig `json:"load_balancer" yaml:"load_balancer"`
+}
+
+// Defaults returns a configuration with every optional setting filled in.
+func Defaults() ScalerConfig {
+	keyFile := "~/.ssh/id_ed25519"
+	if home, err := os.UserHomeDir(); err == nil {
+		keyFile = filepath.Join(home, ".ssh", "id_ed25519")
+	}
+
+	return ScalerConfig{
+		TelemetryStaleAfter: 30 * time.Second,
+		Scaling: ScalingConfig{
+			Interval:  10 * time.Second,
+			Policies:  []string{PolicyThreshold},
+			ScaleUp:   Thresholds{CPU: 80, Memory: 80},
+			ScaleDown: Thresholds{CPU: 20, Memory: 20},
+			Target:    TargetTracking{Tolerance: 0.1},
+			Step: StepScaling{
+				Metric:    "cpu",
+				ScaleUp:   []StepBand{{70, 1}, {85, 2}, {95, 3}},
+				ScaleDown: []StepBand{{30, 1}, {15, 2}, {5, 3}},
+			},
+			Predictive: PredictiveScaling{
+				Metric:   "cpu",
+				Target:   60,
+				Model:    ModelLinear,
+				Window:   10 * time.Minute,
+				LeadTime: 90 * time.Second,
+			},
+			MaxStep:   1,
+			MinAgents: 1,
+
+			ScaleUpBreaches:     2,
+			ScaleDownBreaches:   3,
+			ScaleUpCooldown:     time.Minute,
+			ScaleDownCooldown:   3 * time.Minute,
+			ScaleDownProtection: 5 * time.Minute,
+			DrainTimeout:        2 * time.Minute,
+		},
+		SSH: SSHSettings{
+			KeyFile:        keyFile,
+			ConnectTimeout: 60 * time.Second,
+		},
+		Deploy: DeployConfig{
+			Repo:        "https://github.com/xscotophilic/cluster-ops-playground",
+			ComposePath: "distributed-pluggable-api/compose",
+			Dir:         "pluggable-api",
+			Timeout:     60 * time.Minute,
+		},
+		LoadBalancer: LoadBalancerConfig{
+			UpstreamFile:  "/etc/nginx/conf.d/upstream.conf",
+			UpstreamName:  "backend",
+			ReloadCommand: []string{"sudo", "systemctl", "reload", "nginx"},
+		},
+	}
+}
+
+// Load reads the configuration file at path, or the environment when path is
+// empty, and validates the result.
+func Load(path string) (ScalerConfig, error) {
+	var cfg ScalerConfig
+	var err error
+	if path == "" {
+		cfg, err = FromEnv()
+	} else {
+		cfg, err = FromFile(path)
+	}
+	if err != nil {
+		return ScalerConfig{}, err
+	}
+
+	cfg.applyDefaults()
+	if err := cfg.Validate(); err != nil {
+		return ScalerConfig{}, err
+	}
+	return cfg, nil
 }
 
-func LoadConfig() ScalerConfig {
-	config := ScalerConfig{
-		ServerManagerAPI: os.Getenv("SERVER_MANAGER_API"),
+// applyDefaults fills per-agent settings that fall back to global ones.
+func (c *ScalerConfig) applyDefaults() {
+	if rest, ok := strings.CutPrefix(c.SSH.KeyFile, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			c.SSH.KeyFile = filepath.Join(home, rest)
+		}
 	}
+	if c.Scaling.MaxAgents == 0 {
+		c.Scaling.MaxAgents = len(c.AvailableAgents)
+	}
+	for i := range c.AvailableAgents {
+		if c.AvailableAgents[i].SSH.User == "" {
+			c.AvailableAgents[i].SSH.User = c.SSH.User
+		}
+	}
+}
 
-	agentsJSON := os.Getenv("AGENTS")
-	if agentsJSON != "" {
-		err := json.Unmarshal([]byte(agentsJSON), &config.AvailableAgents)
+// FromFile parses a YAML or JSON configuration file. Unknown fields are
+// rejected so typos do not silently fall back to defaults.
+func FromFile(path string) (ScalerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ScalerConfig{}, err
+	}
+
+	cfg := Defaults()
+	// Decoding into the defaults would merge the default reload command with
+	// a configured one element by element, so sequences are reset first.
+	cfg.LoadBalancer.ReloadCommand = nil
+	cfg.Scaling.Policies = nil
+	cfg.Scaling.Step.ScaleUp = nil
+	cfg.Scaling.Step.ScaleDown = nil
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil {
+		return ScalerConfig{}, fmt.Errorf("parse error: %v", err)
+	}
+	if len(cfg.LoadBalancer.ReloadCommand) == 0 {
+		cfg.LoadBalancer.ReloadCommand = Defaults().LoadBalancer.ReloadCommand
+	}
+	if len(cfg.Scaling.Policies) == 0 {
+		cfg.Scaling.Policies = Defaults().Scaling.Policies
+	}
+	// An explicit empty list disables that direction, so only a missing one
+	// falls back to the default bands.
+	if cfg.Scaling.Step.ScaleUp == nil {
+		cfg.Scaling.Step.ScaleUp = Defaults().Scaling.Step.ScaleUp
+	}
+	if cfg.Scaling.Step.ScaleDown == nil {
+		cfg.Scaling.Step.ScaleDown = Defaults().Scaling.Step.ScaleDown
+	}
+	return cfg, nil
+}
+
+// FromEnv builds the configuration from the environment variables documented
+// in .env.example.
+func FromEnv() (ScalerConfig, error) {
+	cfg := Defaults()
+	var errs ValidationErrors
+
+	cfg.ServerManagerAPI = os.Getenv("SERVER_MANAGER_API")
+	cfg.ServerManagerToken = os.Getenv("SERVER_MANAGER_TOKEN")
+	cfg.IngestAddr = os.Getenv("INGEST_ADDR")
+	cfg.StatusAddr = os.Getenv("STATUS_ADDR")
+	cfg.TelemetrySecret = os.Getenv("TELEMETRY_SECRET")
+
+	durationEnv := func(key string, target *time.Duration) {
+		if value := os.Getenv(key); value != "" {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				errs = append(errs, FieldError{Field: key, Message: fmt.Sprintf("invalid duration %q", value)})
+				return
+			}
+			*target = d
+		}
+	}
+	floatEnv := func(key string, target *float64) {
+		if value := os.Getenv(key); value != "" {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				errs = append(errs, FieldError{Field: key, Message: fmt.Sprintf("invalid number %q", value)})
+				return
+			}
+			*target = f
+		}
+	}
+	intEnv := func(key string, target *int) {
+		if value := os.Getenv(key); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, FieldError{Field: key, Message: fmt.Sprintf("invalid integer %q", value)})
+				return
+			}
+			*target = n
+		}
+	}
+
+	durationEnv("TELEMETRY_STALE_AFTER", &cfg.TelemetryStaleAfter)
+	cfg.StateFile = os.Getenv("STATE_FILE")
+	durationEnv("SCALE_INTERVAL", &cfg.Scaling.Interval)
+	floatEnv("SCALE_UP_CPU", &cfg.Scaling.ScaleUp.CPU)
+	floatEnv("SCALE_UP_MEMORY", &cfg.Scaling.ScaleUp.Memory)
+	floatEnv("SCALE_DOWN_CPU", &cfg.Scaling.ScaleDown.CPU)
+	floatEnv("SCALE_DOWN_MEMORY", &cfg.Scaling.ScaleDown.Memory)
+	if policies := os.Getenv("SCALE_POLICIES"); policies != "" {
+		cfg.Scaling.Policies = strings.Split(policies, ",")
+		for i := range cfg.Scaling.Policies {
+			cfg.Scaling.Policies[i] = strings.TrimSpace(cfg.Scaling.Policies[i])
+		}
+	}
+	floatEnv("TARGET_CPU", &cfg.Scaling.Target.CPU)
+	floatEnv("TARGET_MEMORY", &cfg.Scaling.Target.Memory)
+	floatEnv("TARGET_TOLERANCE", &cfg.Scaling.Target.Tolerance)
+	bandsEnv := func(key string, target *[]StepBand) {
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			return
+		}
+		bands := []StepBand{}
+		for _, band := range strings.Split(value, ",") {
+			if band = strings.TrimSpace(band); band == "" {
+				continue
+			}
+			threshold, agents, found := strings.Cut(band, ":")
+			t, err1 := strconv.ParseFloat(threshold, 64)
+			a, err2 := strconv.Atoi(agents)
+			if !found || err1 != nil || err2 != nil {
+				errs = append(errs, FieldError{Field: key, Message: fmt.Sprintf("invalid band %q, want threshold:agents", band)})
+				return
+			}
+			bands = append(bands, StepBand{Threshold: t, Agents: a})
+		}
+		*target = bands
+	}
+	if metric := os.Getenv("STEP_METRIC"); metric != "" {
+		cfg.Scaling.Step.Metric = metric
+	}
+	bandsEnv("STEP_SCALE_UP", &cfg.Scaling.Step.ScaleUp)
+	bandsEnv("STEP_SCALE_DOWN", &cfg.Scaling.Step.ScaleDown)
+	if metric := os.Getenv("PREDICTIVE_METRIC"); metric != "" {
+		cfg.Scaling.Predictive.Metric = metric
+	}
+	floatEnv("PREDICTIVE_TARGET", &cfg.Scaling.Predictive.Target)
+	if model := os.Getenv("PREDICTIVE_MODEL"); model != "" {
+		cfg.Scaling.Predictive.Model = model
+	}
+	durationEnv("PREDICTIVE_WINDOW", &cfg.Scaling.Predictive.Window)
+	durationEnv("PREDICTIVE_LEAD_TIME", &cfg.Scaling.Predictive.LeadTime)
+	if value := os.Getenv("PREDICTIVE_FORECAST_ONLY"); value != "" {
+		forecastOnly, err := strconv.ParseBool(value)
 		if err != nil {
-			log.Printf("Error parsing AGENTS environment variable: %v\n", err)
+			errs = append(errs, FieldError{Field: "PREDICTIVE_FORECAST_ONLY", Message: fmt.Sprintf("invalid boolean %q", value)})
+		}
+		cfg.Scaling.Predictive.ForecastOnly = forecastOnly
+	}
+	cfg.Scaling.HistoryFile = os.Getenv("HISTORY_FILE")
+	intEnv("SCALE_MAX_STEP", &cfg.Scaling.MaxStep)
+	intEnv("MIN_AGENTS", &cfg.Scaling.MinAgents)
+	intEnv("MAX_AGENTS", &cfg.Scaling.MaxAgents)
+	intEnv("SCALE_UP_BREACHES", &cfg.Scaling.ScaleUpBreaches)
+	intEnv("SCALE_DOWN_BREACHES", &cfg.Scaling.ScaleDownBreaches)
+	durationEnv("SCALE_UP_COOLDOWN", &cfg.Scaling.ScaleUpCooldown)
+	durationEnv("SCALE_DOWN_COOLDOWN", &cfg.Scaling.ScaleDownCooldown)
+	durationEnv("SCALE_DOWN_PROTECTION", &cfg.Scaling.ScaleDownProtection)
+	durationEnv("DRAIN_TIMEOUT", &cfg.Scaling.DrainTimeout)
+
+	if keyFile := os.Getenv("SSH_KEY_FILE"); keyFile != "" {
+		cfg.SSH.KeyFile = keyFile
+	}
+
+	if ref := os.Getenv("PLUGGABLE_API_REF"); ref != "" {
+		cfg.Deploy.Ref = ref
+	}
+	cfg.Deploy.Token = os.Getenv("DEPLOY_TOKEN")
+	cfg.Deploy.Env = map[string]string{
+		"CORS_ORIGINS": os.Getenv("CORS_ORIGINS"),
+		"POSTGRES_URL": os.Getenv("POSTGRES_URL"),
+		"REDIS_URL":    os.Getenv("REDIS_URL"),
+	}
+
+	if schedulesJSON := os.Getenv("SCHEDULES"); schedulesJSON != "" {
+		decoder := json.NewDecoder(bytes.NewReader([]byte(schedulesJSON)))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&cfg.Scaling.Schedules); err != nil {
+			errs = append(errs, FieldError{Field: "SCHEDULES", Message: fmt.Sprintf("invalid JSON: %v", err)})
+		}
+	}
+
+	if agentsJSON := os.Getenv("AGENTS"); agentsJSON != "" {
+		decoder := json.NewDecoder(bytes.NewReader([]byte(agentsJSON)))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&cfg.AvailableAgents); err != nil {
+			errs = append(errs, FieldError{Field: "AGENTS", Message: fmt.Sprintf("invalid JSON: %v", err)})
 		}
 	}
 
-	return config
+	if len(errs) > 0 {
+		return ScalerConfig{}, errs
+	}
+	return cfg, nil
+}
+
+// Path returns the configuration file named by SCALER_CONFIG, if any.
+func Path() string {
+	return os.Getenv("SCALER_CONFIG")
 }
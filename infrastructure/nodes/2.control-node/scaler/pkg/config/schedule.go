@@ -0,0 +1,127 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ScheduleRule overrides min_agents and/or max_agents during a weekly time
+// window. A window whose end is before its start runs past midnight into the
+// next day; Days then name the day it starts on. Empty Days means every day
+// and an empty TimeZone means the scaler's local time.
+type ScheduleRule struct {
+	Name      string   `json:"name" yaml:"name"`
+	Days      []string `json:"days,omitempty" yaml:"days,omitempty"`
+	Start     string   `json:"start" yaml:"start"`
+	End       string   `json:"end" yaml:"end"`
+	TimeZone  string   `json:"timezone,omitempty" yaml:"timezone,omitempty"`
+	MinAgents int      `json:"min_agents,omitempty" yaml:"min_agents,omitempty"`
+	MaxAgents int      `json:"max_agents,omitempty" yaml:"max_agents,omitempty"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseDays expands entries such as "mon", "mon-fri" or "fri-mon" into a set
+// of weekdays.
+func parseDays(days []string) (map[time.Weekday]bool, error) {
+	set := make(map[time.Weekday]bool)
+	if len(days) == 0 {
+		for _, d := range weekdays {
+			set[d] = true
+		}
+		return set, nil
+	}
+	for _, entry := range days {
+		from, to, isRange := strings.Cut(strings.ToLower(strings.TrimSpace(entry)), "-")
+		if !isRange {
+			to = from
+		}
+		first, ok1 := weekdays[from]
+		last, ok2 := weekdays[to]
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("invalid day %q, want e.g. mon or mon-fri", entry)
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			set[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return set, nil
+}
+
+// parseClock parses "HH:MM" into minutes after midnight.
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// location returns the rule's time zone, the scaler's local time when
+// TimeZone is empty. time.LoadLocation("") would return UTC instead.
+func (r ScheduleRule) location() (*time.Location, error) {
+	if r.TimeZone == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(r.TimeZone)
+}
+
+// Active reports whether t falls inside the rule's window. Rules that do not
+// parse are never active; Validate reports them.
+func (r ScheduleRule) Active(t time.Time) bool {
+	days, err := parseDays(r.Days)
+	if err != nil {
+		return false
+	}
+	start, err1 := parseClock(r.Start)
+	end, err2 := parseClock(r.End)
+	loc, err3 := r.location()
+	if err1 != nil || err2 != nil || err3 != nil {
+		return false
+	}
+
+	t = t.In(loc)
+	minute := t.Hour()*60 + t.Minute()
+	today := t.Weekday()
+	yesterday := (today + 6) % 7
+
+	switch {
+	case start == end:
+		return days[today]
+	case start < end:
+		return days[today] && minute >= start && minute < end
+	default:
+		return (days[today] && minute >= start) || (days[yesterday] && minute < end)
+	}
+}
+
+// Bounds returns min_agents and max_agents in effect at t and the names of
+// the schedules that set them. Matching schedules apply in the order they are
+// listed, so a later one overrides what an earlier one set. If the result has
+// min above max, max is raised to min.
+func (c ScalingConfig) Bounds(t time.Time) (minAgents, maxAgents int, active []string) {
+	minAgents, maxAgents = c.MinAgents, c.MaxAgents
+	for _, rule := range c.Schedules {
+		if !rule.Active(t) {
+			continue
+		}
+		active = append(active, rule.Name)
+		if rule.MinAgents > 0 {
+			minAgents = rule.MinAgents
+		}
+		if rule.MaxAgents > 0 {
+			maxAgents = rule.MaxAgents
+		}
+	}
+	if minAgents > maxAgents {
+		maxAgents = minAgents
+	}
+	return minAgents, maxAgents, active
+}
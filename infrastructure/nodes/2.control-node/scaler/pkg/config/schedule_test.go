@@ -0,0 +1,132 @@
+package config
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseDays(t *testing.T) {
+	tests := []struct {
+		days    []string
+		want    []time.Weekday
+		wantErr bool
+	}{
+		{nil, []time.Weekday{time.Sunday, time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday}, false},
+		{[]string{"mon"}, []time.Weekday{time.Monday}, false},
+		{[]string{"mon-fri"}, []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, false},
+		{[]string{"fri-mon"}, []time.Weekday{time.Sunday, time.Monday, time.Friday, time.Saturday}, false},
+		{[]string{" Sat ", "tue"}, []time.Weekday{time.Tuesday, time.Saturday}, false},
+		{[]string{"monday"}, nil, true},
+		{[]string{"mon-"}, nil, true},
+	}
+	for _, tt := range tests {
+		set, err := parseDays(tt.days)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDays(%q) error = %v, want error: %v", tt.days, err, tt.wantErr)
+			continue
+		}
+		var got []time.Weekday
+		for d := range set {
+			got = append(got, d)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("parseDays(%q) = %v, want %v", tt.days, got, tt.want)
+		}
+	}
+}
+
+func TestScheduleRuleActive(t *testing.T) {
+	// 2026-10-19 is a Monday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, time.UTC)
+	}
+	workHours := ScheduleRule{Days: []string{"mon-fri"}, Start: "09:00", End: "17:00", TimeZone: "UTC"}
+	overnight := ScheduleRule{Days: []string{"fri"}, Start: "22:00", End: "06:00", TimeZone: "UTC"}
+	allDay := ScheduleRule{Days: []string{"sat-sun"}, Start: "00:00", End: "00:00", TimeZone: "UTC"}
+	berlin := ScheduleRule{Start: "09:00", End: "17:00", TimeZone: "Europe/Berlin"}
+
+	tests := []struct {
+		name string
+		rule ScheduleRule
+		t    time.Time
+		want bool
+	}{
+		{"at start", workHours, at(19, 9, 0), true},
+		{"just before end", workHours, at(19, 16, 59), true},
+		{"at end", workHours, at(19, 17, 0), false},
+		{"before start", workHours, at(19, 8, 59), false},
+		{"other day", workHours, at(18, 12, 0), false},
+		{"overnight on its day", overnight, at(23, 23, 0), true},
+		{"overnight past midnight", overnight, at(24, 5, 59), true},
+		{"overnight at end", overnight, at(24, 6, 0), false},
+		{"overnight past midnight of another day", overnight, at(23, 5, 0), false},
+		{"all day", allDay, at(25, 3, 0), true},
+		{"all day on another day", allDay, at(19, 3, 0), false},
+		// 07:30 UTC is 09:30 in Berlin, 16:30 UTC is 18:30.
+		{"time zone start", berlin, at(19, 7, 30), true},
+		{"time zone end", berlin, at(19, 16, 30), false},
+		{"invalid time zone", ScheduleRule{Start: "09:00", End: "17:00", TimeZone: "Mars/Olympus"}, at(19, 12, 0), false},
+		{"invalid start", ScheduleRule{Start: "9am", End: "17:00"}, at(19, 12, 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.Active(tt.t); got != tt.want {
+				t.Errorf("Active(%s) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleRuleActiveLocalTime(t *testing.T) {
+	local := time.Local
+	time.Local = time.FixedZone("UTC+2", 2*60*60)
+	defer func() { time.Local = local }()
+
+	rule := ScheduleRule{Start: "09:00", End: "17:00"}
+	tests := []struct {
+		t    time.Time
+		want bool
+	}{
+		{time.Date(2026, 10, 19, 7, 30, 0, 0, time.UTC), true},
+		{time.Date(2026, 10, 19, 15, 30, 0, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		if got := rule.Active(tt.t); got != tt.want {
+			t.Errorf("Active(%s) without a time zone = %v, want %v", tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestBounds(t *testing.T) {
+	monday := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	always := func(name string, minAgents, maxAgents int) ScheduleRule {
+		return ScheduleRule{Name: name, Start: "00:00", End: "00:00", TimeZone: "UTC", MinAgents: minAgents, MaxAgents: maxAgents}
+	}
+	never := ScheduleRule{Name: "weekend", Days: []string{"sat-sun"}, Start: "00:00", End: "00:00", TimeZone: "UTC", MinAgents: 5}
+
+	tests := []struct {
+		name       string
+		schedules  []ScheduleRule
+		wantMin    int
+		wantMax    int
+		wantActive []string
+	}{
+		{"no schedules", nil, 1, 3, nil},
+		{"inactive schedule", []ScheduleRule{never}, 1, 3, nil},
+		{"raises min", []ScheduleRule{always("peak", 2, 0)}, 2, 3, []string{"peak"}},
+		{"later overrides earlier", []ScheduleRule{always("peak", 2, 0), always("sale", 3, 0)}, 3, 3, []string{"peak", "sale"}},
+		{"min above max raises max", []ScheduleRule{always("peak", 4, 0)}, 4, 4, []string{"peak"}},
+		{"lowers max", []ScheduleRule{always("night", 0, 2)}, 1, 2, []string{"night"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ScalingConfig{MinAgents: 1, MaxAgents: 3, Schedules: tt.schedules}
+			minAgents, maxAgents, active := c.Bounds(monday)
+			if minAgents != tt.wantMin || maxAgents != tt.wantMax || !slices.Equal(active, tt.wantActive) {
+				t.Errorf("Bounds() = %d, %d, %v, want %d, %d, %v", minAgents, maxAgents, active, tt.wantMin, tt.wantMax, tt.wantActive)
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldError describes one invalid setting. Field is the path of the setting
+// in the configuration file, e.g. "agents[1].telemetry_url".
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationErrors collects every problem found, so one run reports them all.
+type ValidationErrors []FieldError
+
+func (errs ValidationErrors) Error() string {
+	lines := make([]string, len(errs))
+	for i, e := range errs {
+		lines[i] = e.Error()
+	}
+	return "invalid configuration:\n  " + strings.Join(lines, "\n  ")
+}
+
+// Validate checks the configuration and returns ValidationErrors listing
+// every invalid field, or nil.
+func (c *ScalerConfig) Validate() error {
+	var errs ValidationErrors
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	checkURL := func(field, value string, required bool) {
+		if value == "" {
+			if required {
+				add(field, "is required")
+			}
+			return
+		}
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(field, "must be an http(s) URL, got %q", value)
+		}
+	}
+
+	checkURL("server_manager_api", c.ServerManagerAPI, true)
+
+	if c.IngestAddr != "" {
+		if _, _, err := net.SplitHostPort(c.IngestAddr); err != nil {
+			add("ingest_addr", "must be host:port, got %q", c.IngestAddr)
+		}
+		if c.TelemetrySecret == "" {
+			add("telemetry_secret", "is required when ingest_addr is set")
+		}
+	}
+	if c.StatusAddr != "" {
+		if _, _, err := net.SplitHostPort(c.StatusAddr); err != nil {
+			add("status_addr", "must be host:port, got %q", c.StatusAddr)
+		}
+	}
+	if c.TelemetryStaleAfter <= 0 {
+		add("telemetry_stale_after", "must be positive")
+	}
+
+	if c.Scaling.Interval <= 0 {
+		add("scaling.interval", "must be positive")
+	}
+	for field, value := range map[string]float64{
+		"scaling.scale_up.cpu":      c.Scaling.ScaleUp.CPU,
+		"scaling.scale_up.memory":   c.Scaling.ScaleUp.Memory,
+		"scaling.scale_down.cpu":    c.Scaling.ScaleDown.CPU,
+		"scaling.scale_down.memory": c.Scaling.ScaleDown.Memory,
+	} {
+		if value < 0 || value > 100 {
+			add(field, "must be between 0 and 100, got %g", value)
+		}
+	}
+	if c.Scaling.ScaleDown.CPU >= c.Scaling.ScaleUp.CPU {
+		add("scaling.scale_down.cpu", "must be below scaling.scale_up.cpu")
+	}
+	if c.Scaling.ScaleDown.Memory >= c.Scaling.ScaleUp.Memory {
+		add("scaling.scale_down.memory", "must be below scaling.scale_up.memory")
+	}
+	for i, name := range c.Scaling.Policies {
+		switch name {
+		case PolicyThreshold:
+		case PolicyTarget:
+			target := c.Scaling.Target
+			if target.CPU <= 0 && target.Memory <= 0 {
+				add("scaling.target", "needs a cpu or memory target for the target policy")
+			}
+			if target.CPU < 0 || target.CPU > 100 {
+				add("scaling.target.cpu", "must be between 0 and 100, got %g", target.CPU)
+			}
+			if target.Memory < 0 || target.Memory > 100 {
+				add("scaling.target.memory", "must be between 0 and 100, got %g", target.Memory)
+			}
+			if target.Tolerance < 0 || target.Tolerance >= 1 {
+				add("scaling.target.tolerance", "must be at least 0 and below 1, got %g", target.Tolerance)
+			}
+		case PolicyStep:
+			c.Scaling.Step.validate(add)
+		case PolicyPredictive:
+			c.Scaling.Predictive.validate(add)
+		default:
+			add(fmt.Sprintf("scaling.policies[%d]", i), "must be %q, %q, %q or %q, got %q", PolicyThreshold, PolicyTarget, PolicyStep, PolicyPredictive, name)
+		}
+	}
+	if c.Scaling.MaxStep < 1 {
+		add("scaling.max_step", "must be at least 1, got %d", c.Scaling.MaxStep)
+	}
+
+	// At least one agent has to run to measure load at all.
+	if c.Scaling.MinAgents < 1 {
+		add("scaling.min_agents", "must be at least 1, got %d", c.Scaling.MinAgents)
+	}
+	// Without agents max_agents defaults to 0; the missing agents are
+	// reported on their own.
+	if len(c.AvailableAgents) > 0 {
+		if c.Scaling.MaxAgents < c.Scaling.MinAgents {
+			add("scaling.max_agents", "must not be below scaling.min_agents")
+		} else if c.Scaling.MaxAgents > len(c.AvailableAgents) {
+			add("scaling.max_agents", "must not exceed the %d configured agents, got %d", len(c.AvailableAgents), c.Scaling.MaxAgents)
+		}
+	}
+	for i, rule := range c.Scaling.Schedules {
+		field := fmt.Sprintf("scaling.schedules[%d]", i)
+		if rule.Name == "" {
+			add(field+".name", "is required")
+		}
+		if _, err := parseDays(rule.Days); err != nil {
+			add(field+".days", "%v", err)
+		}
+		if _, err := parseClock(rule.Start); err != nil {
+			add(field+".start", "%v", err)
+		}
+		if _, err := parseClock(rule.End); err != nil {
+			add(field+".end", "%v", err)
+		}
+		if _, err := rule.location(); err != nil {
+			add(field+".timezone", "unknown time zone %q", rule.TimeZone)
+		}
+		if rule.MinAgents == 0 && rule.MaxAgents == 0 {
+			add(field, "must set min_agents or max_agents")
+		}
+		if rule.MinAgents < 0 || rule.MaxAgents < 0 {
+			add(field, "min_agents and max_agents must not be negative")
+		}
+		if rule.MinAgents > 0 && rule.MaxAgents > 0 && rule.MaxAgents < rule.MinAgents {
+			add(field+".max_agents", "must not be below min_agents")
+		}
+		if n := len(c.AvailableAgents); n > 0 && (rule.MinAgents > n || rule.MaxAgents > n) {
+			add(field, "must not exceed the %d configured agents", n)
+		}
+	}
+	if c.Scaling.ScaleUpBreaches < 1 {
+		add("scaling.scale_up_breaches", "must be at least 1, got %d", c.Scaling.ScaleUpBreaches)
+	}
+	if c.Scaling.ScaleDownBreaches < 1 {
+		add("scaling.scale_down_breaches", "must be at least 1, got %d", c.Scaling.ScaleDownBreaches)
+	}
+	for field, value := range map[string]time.Duration{
+		"scaling.scale_up_cooldown":     c.Scaling.ScaleUpCooldown,
+		"scaling.scale_down_cooldown":   c.Scaling.ScaleDownCooldown,
+		"scaling.scale_down_protection": c.Scaling.ScaleDownProtection,
+		"scaling.drain_timeout":         c.Scaling.DrainTimeout,
+	} {
+		if value < 0 {
+			add(field, "must not be negative")
+		}
+	}
+
+	if c.SSH.KeyFile == "" {
+		add("ssh.key_file", "is required")
+	}
+	if c.SSH.ConnectTimeout <= 0 {
+		add("ssh.connect_timeout", "must be positive")
+	}
+
+	if c.Deploy.Repo == "" {
+		add("deploy.repo", "is required")
+	}
+	if c.Deploy.ComposePath == "" {
+		add("deploy.compose_path", "is required")
+	}
+	if c.Deploy.Dir == "" || strings.HasPrefix(c.Deploy.Dir, "/") || strings.Contains(c.Deploy.Dir, "..") {
+		add("deploy.dir", "must be a relative path inside the agent user's home, got %q", c.Deploy.Dir)
+	}
+	if c.Deploy.Timeout <= 0 {
+		add("deploy.timeout", "must be positive")
+	}
+	for key := range c.Deploy.Env {
+		if key == "" || strings.ContainsAny(key, "=\r\n ") {
+			add("deploy.env", "invalid variable name %q", key)
+		}
+	}
+
+	if c.LoadBalancer.UpstreamFile == "" {
+		add("load_balancer.upstream_file", "is required")
+	}
+	if c.LoadBalancer.UpstreamName == "" {
+		add("load_balancer.upstream_name", "is required")
+	}
+	if len(c.LoadBalancer.ReloadCommand) == 0 {
+		add("load_balancer.reload_command", "is required")
+	}
+
+	if len(c.AvailableAgents) == 0 {
+		add("agents", "at least one agent is required")
+	}
+	names := make(map[string]int)
+	for i, agent := range c.AvailableAgents {
+		field := fmt.Sprintf("agents[%d]", i)
+
+		if agent.ServerName == "" {
+			add(field+".server_name", "is required")
+		} else if first, ok := names[agent.ServerName]; ok {
+			add(field+".server_name", "duplicate name %q, also used by agents[%d]", agent.ServerName, first)
+		} else {
+			names[agent.ServerName] = i
+		}
+
+		checkURL(field+".upstream_url", agent.UpstreamURL, true)
+		checkURL(field+".telemetry_url", agent.TelemetryURL, true)
+		checkURL(field+".ready_url", agent.ReadyURL, false)
+		checkURL(field+".info_url", agent.InfoURL, false)
+		// The info check only proves which machine serves the metrics the
+		// scaler reads when both come from the same metrics API.
+		if agent.InfoURL != "" && agent.TelemetryURL != "" && !sameOrigin(agent.InfoURL, agent.TelemetryURL) {
+			add(field+".info_url", "must use the same scheme, host and port as telemetry_url %q, got %q", agent.TelemetryURL, agent.InfoURL)
+		}
+		checkURL(field+".deploy_url", agent.DeployURL, false)
+		if agent.DeployURL != "" && c.Deploy.Token == "" {
+			add(field+".deploy_url", "requires deploy.token")
+		}
+		if agent.JobsMetric != "" && !strings.Contains(strings.Trim(agent.JobsMetric, "."), ".") {
+			add(field+".jobs_metric", "must be <collector>.<metric>, got %q", agent.JobsMetric)
+		}
+
+		if agent.SSH.IP == "" {
+			add(field+".ssh.ip", "is required")
+		}
+		if agent.SSH.Port == "" {
+			add(field+".ssh.port", "is required")
+		} else if port, err := strconv.Atoi(agent.SSH.Port); err != nil || port < 1 || port > 65535 {
+			add(field+".ssh.port", "must be a port number, got %q", agent.SSH.Port)
+		}
+		if agent.SSH.User == "" {
+			add(field+".ssh.user", "is required (or set ssh.user as the default)")
+		}
+	}
+
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+		return errs
+	}
+	return nil
+}
+
+// sameOrigin reports whether two http(s) URLs share scheme, host and port,
+// filling in the scheme's default port. Unparsable URLs are reported by
+// checkURL and count as matching here.
+func sameOrigin(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	if errA != nil || errB != nil {
+		return true
+	}
+	port := func(u *url.URL) string {
+		if p := u.Port(); p != "" {
+			return p
+		}
+		if u.Scheme == "https" {
+			return "443"
+		}
+		return "80"
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) &&
+		strings.EqualFold(ua.Hostname(), ub.Hostname()) &&
+		port(ua) == port(ub)
+}
+
+func (s StepScaling) validate(add func(field, format string, args ...interface{})) {
+	if s.Metric != "cpu" && s.Metric != "memory" {
+		add("scaling.step.metric", "must be \"cpu\" or \"memory\", got %q", s.Metric)
+	}
+	if len(s.ScaleUp) == 0 {
+		add("scaling.step.scale_up", "needs at least one band")
+	}
+
+	lowestUp := 100.0
+	for field, bands := range map[string][]StepBand{"scaling.step.scale_up": s.ScaleUp, "scaling.step.scale_down": s.ScaleDown} {
+		seen := make(map[float64]bool)
+		for i, band := range bands {
+			f := fmt.Sprintf("%s[%d]", field, i)
+			if band.Threshold < 0 || band.Threshold > 100 {
+				add(f+".threshold", "must be between 0 and 100, got %g", band.Threshold)
+			}
+			if seen[band.Threshold] {
+				add(f+".threshold", "duplicate threshold %g", band.Threshold)
+			}
+			seen[band.Threshold] = true
+			if band.Agents < 1 {
+				add(f+".agents", "must be at least 1, got %d", band.Agents)
+			}
+		}
+	}
+	for _, band := range s.ScaleUp {
+		lowestUp = min(lowestUp, band.Threshold)
+	}
+	for i, band := range s.ScaleDown {
+		if band.Threshold >= lowestUp {
+			add(fmt.Sprintf("scaling.step.scale_down[%d].threshold", i), "must be below every scale_up threshold")
+		}
+	}
+}
+
+func (p PredictiveScaling) validate(add func(field, format string, args ...interface{})) {
+	if p.Metric != "cpu" && p.Metric != "memory" {
+		add("scaling.predictive.metric", "must be \"cpu\" or \"memory\", got %q", p.Metric)
+	}
+	if p.Target <= 0 || p.Target > 100 {
+		add("scaling.predictive.target", "must be above 0 and at most 100, got %g", p.Target)
+	}
+	if p.Model != ModelLinear && p.Model != ModelHolt {
+		add("scaling.predictive.model", "must be %q or %q, got %q", ModelLinear, ModelHolt, p.Model)
+	}
+	if p.Window <= 0 {
+		add("scaling.predictive.window", "must be positive")
+	}
+	if p.LeadTime < 0 {
+		add("scaling.predictive.lead_time", "must not be negative")
+	}
+}
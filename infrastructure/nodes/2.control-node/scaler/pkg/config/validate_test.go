@@ -0,0 +1,143 @@
+package config
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// validConfig returns the defaults with the required settings filled in for
+// two agents.
+func validConfig() ScalerConfig {
+	cfg := Defaults()
+	cfg.ServerManagerAPI = "http://192.168.1.8:3000"
+	cfg.SSH.User = "agent"
+	cfg.AvailableAgents = []AgentConfig{
+		{
+			ServerName:   "frodo",
+			UpstreamURL:  "http://192.168.1.8:5001",
+			TelemetryURL: "http://192.168.1.8:5101/metrics",
+			InfoURL:      "http://192.168.1.8:5101/info",
+			SSH:          SSHConfig{IP: "192.168.1.8", Port: "2224"},
+		},
+		{
+			ServerName:   "samwise",
+			UpstreamURL:  "http://192.168.1.8:5002",
+			TelemetryURL: "http://192.168.1.8:5102/metrics",
+			InfoURL:      "http://192.168.1.8:5102/info",
+			SSH:          SSHConfig{IP: "192.168.1.8", Port: "2225"},
+		},
+	}
+	cfg.applyDefaults()
+	return cfg
+}
+
+// fieldErrors returns the fields named by err, which must be ValidationErrors.
+func fieldErrors(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Validate() error = %T, want ValidationErrors", err)
+	}
+	fields := make([]string, len(errs))
+	for i, e := range errs {
+		fields[i] = e.Field
+	}
+	return fields
+}
+
+func TestValidateInfoURLOrigin(t *testing.T) {
+	tests := []struct {
+		name      string
+		telemetry string
+		info      string
+		wantErr   bool
+	}{
+		{"same host and port", "http://192.168.1.8:5101/metrics", "http://192.168.1.8:5101/info", false},
+		{"default port spelled out", "http://agent-1.local/metrics", "http://agent-1.local:80/info", false},
+		{"host case", "http://Agent-1.local:5101/metrics", "http://agent-1.local:5101/info", false},
+		{"other port", "http://192.168.1.8:5101/metrics", "http://192.168.1.8:5102/info", true},
+		{"other host", "http://192.168.1.8:5101/metrics", "http://192.168.1.9:5101/info", true},
+		{"other scheme", "http://192.168.1.8:5101/metrics", "https://192.168.1.8:5101/info", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			cfg.AvailableAgents[0].TelemetryURL = tt.telemetry
+			cfg.AvailableAgents[0].InfoURL = tt.info
+
+			fields := fieldErrors(t, cfg.Validate())
+			if !tt.wantErr {
+				if len(fields) != 0 {
+					t.Errorf("Validate() reported %v, want no errors", fields)
+				}
+				return
+			}
+			if len(fields) != 1 || fields[0] != "agents[0].info_url" {
+				t.Errorf("Validate() reported %v, want [agents[0].info_url]", fields)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	base := validConfig()
+	if err := base.Validate(); err != nil {
+		t.Fatalf("Validate() of the base configuration error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*ScalerConfig)
+		field  string
+	}{
+		{"min_agents above max_agents", func(c *ScalerConfig) { c.Scaling.MinAgents, c.Scaling.MaxAgents = 2, 1 }, "scaling.max_agents"},
+		{"max_agents above agents", func(c *ScalerConfig) { c.Scaling.MaxAgents = 3 }, "scaling.max_agents"},
+		{"zero min_agents", func(c *ScalerConfig) { c.Scaling.MinAgents = 0 }, "scaling.min_agents"},
+		{"unknown policy", func(c *ScalerConfig) { c.Scaling.Policies = []string{PolicyThreshold, "random"} }, "scaling.policies[1]"},
+		{"zero interval", func(c *ScalerConfig) { c.Scaling.Interval = 0 }, "scaling.interval"},
+		{"negative cooldown", func(c *ScalerConfig) { c.Scaling.ScaleUpCooldown = -time.Second }, "scaling.scale_up_cooldown"},
+		{"scale down above scale up", func(c *ScalerConfig) { c.Scaling.ScaleDown.CPU = 90 }, "scaling.scale_down.cpu"},
+		{"duplicate server_name", func(c *ScalerConfig) { c.AvailableAgents[1].ServerName = "frodo" }, "agents[1].server_name"},
+		{"missing server_name", func(c *ScalerConfig) { c.AvailableAgents[0].ServerName = "" }, "agents[0].server_name"},
+		{"server_manager_api not http", func(c *ScalerConfig) { c.ServerManagerAPI = "ftp://192.168.1.8:3000" }, "server_manager_api"},
+		{"missing server_manager_api", func(c *ScalerConfig) { c.ServerManagerAPI = "" }, "server_manager_api"},
+		{"upstream_url without host", func(c *ScalerConfig) { c.AvailableAgents[0].UpstreamURL = "http://" }, "agents[0].upstream_url"},
+		{"telemetry_url without scheme", func(c *ScalerConfig) { c.AvailableAgents[1].TelemetryURL = "192.168.1.8:5102/metrics" }, "agents[1].telemetry_url"},
+		{"ready_url not a URL", func(c *ScalerConfig) { c.AvailableAgents[0].ReadyURL = "http://192.168.1.8:port/ready" }, "agents[0].ready_url"},
+		{"deploy_url without token", func(c *ScalerConfig) { c.AvailableAgents[0].DeployURL = "http://192.168.1.8:5101/api/v1/deploy" }, "agents[0].deploy_url"},
+		{"ssh port out of range", func(c *ScalerConfig) { c.AvailableAgents[0].SSH.Port = "70000" }, "agents[0].ssh.port"},
+		{"ingest without secret", func(c *ScalerConfig) { c.IngestAddr = ":7000" }, "telemetry_secret"},
+		{"ingest not host:port", func(c *ScalerConfig) { c.IngestAddr, c.TelemetrySecret = "7000", "secret" }, "ingest_addr"},
+		{"status_addr not host:port", func(c *ScalerConfig) { c.StatusAddr = "7001" }, "status_addr"},
+		{"absolute deploy dir", func(c *ScalerConfig) { c.Deploy.Dir = "/opt/pluggable-api" }, "deploy.dir"},
+		{"missing reload command", func(c *ScalerConfig) { c.LoadBalancer.ReloadCommand = nil }, "load_balancer.reload_command"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.modify(&cfg)
+
+			fields := fieldErrors(t, cfg.Validate())
+			if len(fields) != 1 || fields[0] != tt.field {
+				t.Errorf("Validate() reported %v, want [%s]", fields, tt.field)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryField(t *testing.T) {
+	cfg := validConfig()
+	cfg.Scaling.Interval = 0
+	cfg.AvailableAgents[1].ServerName = "frodo"
+	cfg.ServerManagerAPI = ""
+
+	want := []string{"agents[1].server_name", "scaling.interval", "server_manager_api"}
+	if fields := fieldErrors(t, cfg.Validate()); !slices.Equal(fields, want) {
+		t.Errorf("Validate() reported %v, want %v", fields, want)
+	}
+}
@@ -0,0 +1,105 @@
+package deploy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"scaler/pkg/config"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+type deploySpec struct {
+	Repo        string            `json:"repo"`
+	Ref         string            `json:"ref,omitempty"`
+	ComposePath string            `json:"compose_path"`
+	Env         map[string]string `json:"env"`
+}
+
+type deployEvent struct {
+	Time    time.Time `json:"time"`
+	Step    string    `json:"step"`
+	Message string    `json:"message"`
+	Release *struct {
+		ID         string `json:"id"`
+		Status     string `json:"status"`
+		Revision   string `json:"revision"`
+		RolledBack string `json:"rolled_back_to"`
+		Error      string `json:"error"`
+	} `json:"release"`
+}
+
+// deployViaAgent POSTs a deploy spec to the agent's metrics API deploy
+// endpoint and logs its progress stream as it arrives.
+func deployViaAgent(ctx context.Context, cfg config.DeployConfig, agent config.AgentConfig) error {
+	spec := deploySpec{
+		Repo:        cfg.Repo,
+		Ref:         cfg.Ref,
+		ComposePath: cfg.ComposePath,
+		Env:         cfg.Env,
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, agent.DeployURL, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+cfg.Token)
+
+	client := http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return fmt.Errorf("deploy api returned status %d: %s", resp.StatusCode, body.Error)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event deployEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("invalid deploy event: %v", err)
+		}
+
+		if event.Step != "done" {
+			log.Printf("[%s] %s: %s", agent.ServerName, event.Step, event.Message)
+			continue
+		}
+		if event.Release == nil {
+			return fmt.Errorf("deploy finished without a release record")
+		}
+		if event.Release.Status != "succeeded" {
+			if event.Release.RolledBack != "" {
+				return fmt.Errorf("release %s failed, rolled back to %s: %s", event.Release.ID, event.Release.RolledBack, event.Release.Error)
+			}
+			return fmt.Errorf("release %s failed: %s", event.Release.ID, event.Release.Error)
+		}
+		log.Printf("[%s] released %s at revision %s", agent.ServerName, event.Release.ID, event.Release.Revision)
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("deploy stream ended before the deploy finished")
+}
@@ -8,7 +8,11 @@ import (
 	"os"
 	"os/exec"
 	"scaler/pkg/config"
-	"time"
+	"scaler/pkg/observability"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 const remoteScript = `
@@ -23,9 +27,8 @@ fi
 pluggable_api_repo="$1"
 relative_compose_root="$2"
 target_sub_dir="$3"
-cors_b64="$4"
-postgres_b64="$5"
-redis_b64="$6"
+env_b64="$4"
+git_ref="${5:-}"
 
 decode_b64() {
   local input="$1"
@@ -46,9 +49,7 @@ decode_b64() {
   return 0
 }
 
-cors_origins="$(decode_b64 "${cors_b64}")"
-postgres_url="$(decode_b64 "${postgres_b64}")"
-redis_url="$(decode_b64 "${redis_b64}")"
+env_content="$(decode_b64 "${env_b64}")"
 
 case "$relative_compose_root" in
   /*) ;;
@@ -120,10 +121,21 @@ cleanup() {
 trap cleanup EXIT
 
 # clone repository
-echo "[INFO] Cloning repository $pluggable_api_repo into $tmpdir/repo"
-if ! git clone --depth=1 "$pluggable_api_repo" "$tmpdir/repo"; then
-    echo "[ERROR] Repository clone failed."
-    exit 4
+if [ -n "$git_ref" ]; then
+    echo "[INFO] Fetching $git_ref from $pluggable_api_repo into $tmpdir/repo"
+    if ! { git init -q "$tmpdir/repo" \
+        && git -C "$tmpdir/repo" remote add origin "$pluggable_api_repo" \
+        && git -C "$tmpdir/repo" fetch --depth=1 origin "$git_ref" \
+        && git -C "$tmpdir/repo" checkout -q FETCH_HEAD; }; then
+        echo "[ERROR] Repository fetch failed."
+        exit 4
+    fi
+else
+    echo "[INFO] Cloning repository $pluggable_api_repo into $tmpdir/repo"
+    if ! git clone --depth=1 "$pluggable_api_repo" "$tmpdir/repo"; then
+        echo "[ERROR] Repository clone failed."
+        exit 4
+    fi
 fi
 
 # prepare safe backup/replacement
@@ -183,20 +195,8 @@ fi
 env_file="$compose_dir/.env"
 mkdir -p "$(dirname "$env_file")"
 
-# sanitize values
-sanitize_value() {
-  printf "%s" "$1" | tr -d '\r\n'
-}
-
-cors_line=$(sanitize_value "$cors_origins")
-pg_line=$(sanitize_value "$postgres_url")
-redis_line=$(sanitize_value "$redis_url")
-
-{
-  printf '%s\n' "CORS_ORIGINS=${cors_line}"
-  printf '%s\n' "POSTGRES_URL=${pg_line}"
-  printf '%s\n' "REDIS_URL=${redis_line}"
-} > "$env_file"
+# values are already stripped of newlines by the scaler
+printf '%s\n' "$env_content" > "$env_file"
 
 chmod 600 "$env_file" || true
 echo "[INFO] Wrote $env_file"
@@ -286,42 +286,40 @@ sudo docker compose -f "$compose_file" logs --tail=100 || true
 exit 0
 `
 
-func DeployPluggableAPI(agent config.AgentConfig) error {
-	timeout := 60 * time.Minute
-
-	pluggableApiRepo := "https://github.com/xscotophilic/cluster-ops-playground"
-	relativeComposePath := "distributed-pluggable-api/compose"
-	pluggableApiDir := "pluggable-api"
-
-	cors := os.Getenv("CORS_ORIGINS")
-	pg := os.Getenv("POSTGRES_URL")
-	redis := os.Getenv("REDIS_URL")
+// DeployPluggableAPI deploys through the agent's deploy API when the agent has
+// a deploy_url, and over SSH otherwise.
+func DeployPluggableAPI(ctx context.Context, cfg config.DeployConfig, ssh config.SSHSettings, agent config.AgentConfig) (err error) {
+	ctx, span := observability.Tracer.Start(ctx, "deploy")
+	span.SetAttributes(attribute.String("agent", agent.ServerName))
+	defer func() { observability.End(span, err) }()
 
-	corsB64 := base64.StdEncoding.EncodeToString([]byte(cors))
-	pgB64 := base64.StdEncoding.EncodeToString([]byte(pg))
-	redisB64 := base64.StdEncoding.EncodeToString([]byte(redis))
-
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return fmt.Errorf("error getting home directory: %v", err)
+	if agent.DeployURL != "" {
+		span.SetAttributes(attribute.String("method", "agent"))
+		return deployViaAgent(ctx, cfg, agent)
 	}
+	span.SetAttributes(attribute.String("method", "ssh"))
+	return deployViaSSH(ctx, cfg, ssh, agent)
+}
+
+func deployViaSSH(ctx context.Context, cfg config.DeployConfig, ssh config.SSHSettings, agent config.AgentConfig) error {
+	envB64 := base64.StdEncoding.EncodeToString([]byte(envFile(cfg.Env)))
 
 	commandArgs := []string{
 		"-p", agent.SSH.Port,
 		"-o", "StrictHostKeyChecking=no",
 		"-o", "UserKnownHostsFile=/dev/null",
-		"-i", fmt.Sprintf("%s/.ssh/id_ed25519", homeDir),
+		"-i", ssh.KeyFile,
+		"-o", fmt.Sprintf("ConnectTimeout=%d", int(ssh.ConnectTimeout.Seconds())),
 		fmt.Sprintf("%s@%s", agent.SSH.User, agent.SSH.IP),
 		"bash", "-s", "--",
-		pluggableApiRepo,
-		relativeComposePath,
-		pluggableApiDir,
-		corsB64,
-		pgB64,
-		redisB64,
+		cfg.Repo,
+		cfg.ComposePath,
+		cfg.Dir,
+		envB64,
+		cfg.Ref,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, "ssh", commandArgs...)
@@ -335,3 +333,20 @@ func DeployPluggableAPI(agent config.AgentConfig) error {
 	fmt.Fprintln(os.Stdout, string(output))
 	return nil
 }
+
+// envFile renders env as a .env file with keys sorted. Newlines are stripped
+// from values so each one stays on its own line.
+func envFile(env map[string]string) string {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		value := strings.NewReplacer("\r", "", "\n", "").Replace(env[key])
+		fmt.Fprintf(&b, "%s=%s\n", key, value)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -1,177 +1,427 @@
 package engine
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"scaler/pkg/config"
-	"scaler/pkg/deploy"
 	"scaler/pkg/node"
+	"scaler/pkg/observability"
+	"scaler/pkg/policy"
+	"scaler/pkg/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	avgCPUGauge, _ = observability.Meter.Float64Gauge("scaler.cpu.utilization.avg",
+		metric.WithUnit("%"), metric.WithDescription("Average CPU utilization across active agents."))
+	avgMemGauge, _ = observability.Meter.Float64Gauge("scaler.memory.utilization.avg",
+		metric.WithUnit("%"), metric.WithDescription("Average memory utilization across active agents."))
+	activeAgentsGauge, _ = observability.Meter.Int64Gauge("scaler.agents.active",
+		metric.WithDescription("Agents currently in the load balancer upstream."))
+	scaleCounter, _ = observability.Meter.Int64Counter("scaler.scale.events",
+		metric.WithDescription("Scale-up and scale-down attempts."))
 )
 
+// ScalerEngine decides how many agents should run and reconciles the agents
+// towards that. ActiveAgents are the agents in the load balancer upstream.
 type ScalerEngine struct {
 	Config       config.ScalerConfig
 	ActiveAgents []config.AgentConfig
+	Telemetry    *telemetry.Store
 	mu           sync.Mutex
-	isScaling    bool
+	// machineIDs maps the machine ID reported by each verified agent's
+	// /info endpoint to the agent's server name.
+	machineIDs map[string]string
+
+	// upBreaches and downBreaches count consecutive evaluations above the
+	// scale-up or below the scale-down thresholds.
+	upBreaches    int
+	downBreaches  int
+	lastScaleUp   time.Time
+	lastScaleDown time.Time
+
+	policy  policy.Policy
+	history []policy.Sample
+	// leadTime is the measured time from powering on an agent until it is in
+	// the upstream, zero until the first scale-up.
+	leadTime time.Duration
+
+	// minAgents and maxAgents are the bounds in effect after applying the
+	// active schedules, named in schedules.
+	minAgents int
+	maxAgents int
+	schedules []string
+
+	// agents holds the desired and observed state of each configured
+	// agent; adopted is set once the running agents were adopted as the
+	// initial desired set. upstream names the agents in the last upstream
+	// written, which is only trusted once upstreamSynced is set.
+	agents         map[string]*agentState
+	adopted        bool
+	upstream       []string
+	upstreamSynced bool
+
+	status Status
 }
 
-func NewScalerEngine(cfg config.ScalerConfig) *ScalerEngine {
-	return &ScalerEngine{
+// maxHistory is the least number of evaluations kept for policies, an hour
+// at the default interval.
+const maxHistory = 360
+
+func NewScalerEngine(cfg config.ScalerConfig) (*ScalerEngine, error) {
+	p, err := policy.New(cfg.Scaling)
+	if err != nil {
+		return nil, err
+	}
+	s := &ScalerEngine{
 		Config:       cfg,
 		ActiveAgents: []config.AgentConfig{},
+		machineIDs:   make(map[string]string),
+		policy:       p,
+		agents:       make(map[string]*agentState),
+	}
+	if err := s.loadHistory(); err != nil {
+		log.Printf("Error loading metrics history from %s: %v", cfg.Scaling.HistoryFile, err)
+	}
+	if err := s.loadUpstream(); err != nil {
+		log.Printf("Error reading the upstream config from %s: %v", cfg.LoadBalancer.UpstreamFile, err)
 	}
+	if err := s.loadState(); err != nil {
+		log.Printf("Error loading engine state from %s: %v", cfg.StateFile, err)
+	}
+	return s, nil
 }
 
-func (s *ScalerEngine) CheckAndScaleUp() {
+// Reload swaps in a new configuration between evaluations. Active agents that
+// are still configured pick up their new settings, and the upstream is
+// rewritten on the next loop. Agents that were removed from the configuration
+// leave the upstream but are no longer managed and stay running.
+func (s *ScalerEngine) Reload(cfg config.ScalerConfig) error {
+	p, err := policy.New(cfg.Scaling)
+	if err != nil {
+		return err
+	}
+
 	s.mu.Lock()
-	if s.isScaling {
-		s.mu.Unlock()
-		return
+	defer s.mu.Unlock()
+
+	byName := make(map[string]config.AgentConfig, len(cfg.AvailableAgents))
+	for _, ag := range cfg.AvailableAgents {
+		byName[ag.ServerName] = ag
+	}
+
+	active := []config.AgentConfig{}
+	for _, ag := range s.ActiveAgents {
+		if updated, ok := byName[ag.ServerName]; ok {
+			active = append(active, updated)
+		}
+	}
+	for name, st := range s.agents {
+		if _, ok := byName[name]; !ok {
+			if st.powered {
+				log.Printf("Agent %s was removed from the configuration; it is no longer managed and stays running", name)
+			}
+			delete(s.agents, name)
+		}
 	}
-	s.isScaling = true
-	s.mu.Unlock()
 
+	s.Config = cfg
+	s.ActiveAgents = active
+	s.upstreamSynced = false
+	s.policy = p
+	return nil
+}
+
+// EvaluateScaling runs one evaluation inside a "scaler.evaluate" span, so a
+// scale-up shows as a single trace from the metrics fetch to the nginx reload.
+// It observes the agents, decides how many should run and then reconciles
+// the agents towards that.
+func (s *ScalerEngine) EvaluateScaling(ctx context.Context) {
+	ctx, span := observability.Tracer.Start(ctx, "scaler.evaluate")
+	defer span.End()
+	var st Status
 	defer func() {
-		s.mu.Lock()
-		s.isScaling = false
-		s.mu.Unlock()
+		activeAgentsGauge.Record(ctx, int64(len(s.ActiveAgents)))
+		if err := s.saveState(); err != nil {
+			log.Printf("Error saving engine state to %s: %v", s.Config.StateFile, err)
+		}
+		s.publishStatus(&st)
 	}()
 
-	s.ActiveAgents = []config.AgentConfig{}
-	seen := make(map[string]bool)
+	s.updateBounds(time.Now())
+	if !s.observe(ctx) {
+		st.DesiredAgents = s.desiredCount()
+		st.Decision = "server manager unreachable"
+		return
+	}
+	s.decide(ctx, span, &st)
+	s.reconcile(ctx)
+}
 
-	for _, ag := range s.Config.AvailableAgents {
-		if node.IsActive(ag) {
-			s.registerAgent(ag)
-			seen[ag.ServerName] = true
+// decide updates the desired set from the bounds and the scaling policy and
+// records the outcome in st.
+func (s *ScalerEngine) decide(ctx context.Context, span trace.Span, st *Status) {
+	current := s.desiredCount()
+	if current < s.minAgents {
+		st.DesiredAgents = s.minAgents
+		st.Decision = "below min_agents"
+		s.upBreaches, s.downBreaches = 0, 0
+		// Failed agents stay desired while they are retried, so the count can
+		// stay below min_agents with nothing left to add. Only agents that are
+		// actually added start the scale-up cooldown.
+		before := s.desiredTotal()
+		s.setDesired(s.minAgents)
+		if s.desiredTotal() > before {
+			log.Printf("%d agents desired, scaling up to min_agents (%d)...", current, s.minAgents)
+			s.lastScaleUp = time.Now()
 		}
+		return
+	}
+	if current > s.maxAgents {
+		log.Printf("%d agents desired, scaling down to max_agents (%d)...", current, s.maxAgents)
+		st.DesiredAgents = s.maxAgents
+		st.Decision = "above max_agents"
+		s.setDesired(s.maxAgents)
+		s.upBreaches, s.downBreaches = 0, 0
+		s.lastScaleDown = time.Now()
+		return
 	}
 
-	var firstInactiveAgent *config.AgentConfig
-	for i := range s.Config.AvailableAgents {
-		if !seen[s.Config.AvailableAgents[i].ServerName] {
-			firstInactiveAgent = &s.Config.AvailableAgents[i]
-			break
-		}
+	st.DesiredAgents = current
+	snap, ok := s.snapshot(ctx)
+	if !ok {
+		st.Decision = "no metrics from any active agent"
+		return
 	}
+	st.CPU, st.Memory = snap.CPU, snap.Memory
 
-	if firstInactiveAgent != nil {
-		if len(s.ActiveAgents) > 0 {
-			log.Println("High load detected, scaling up...")
-		}
-		if err := node.ManagePower(s.Config.ServerManagerAPI, firstInactiveAgent.ServerName, "on"); err != nil {
-			log.Printf("Error starting agent %s: %v", firstInactiveAgent.ServerName, err)
+	log.Printf("Average CPU Utilization: %.2f%%, Average Memory Utilization: %.2f%%", snap.CPU, snap.Memory)
+	span.SetAttributes(attribute.Float64("cpu.avg", snap.CPU), attribute.Float64("memory.avg", snap.Memory))
+	avgCPUGauge.Record(ctx, snap.CPU)
+	avgMemGauge.Record(ctx, snap.Memory)
+
+	decision := s.policy.Decide(snap)
+	wanted := countStarting(decision.Desired, len(snap.Active), current)
+	reason := decision.Policy + ": " + decision.Reason
+	desired := s.clampStep(wanted, current)
+	span.SetAttributes(attribute.String("policy", decision.Policy), attribute.Int("agents.desired", desired))
+	st.DesiredAgents = desired
+	st.Decision = reason
+	st.Forecast = decision.Forecast
+	if decision.Forecast != nil {
+		log.Printf("Forecast: %s", decision.Forecast)
+		span.SetAttributes(attribute.Float64("forecast.demand", decision.Forecast.Forecast), attribute.Int("forecast.desired", decision.Forecast.Desired))
+	}
+
+	switch {
+	case wanted > current:
+		s.upBreaches++
+		s.downBreaches = 0
+		if suppressed := s.scaleUpSuppressed(desired); suppressed != "" {
+			log.Printf("Scale-up wanted (%s), not scaling up: %s", reason, suppressed)
+			span.SetAttributes(attribute.String("suppressed", suppressed))
+			st.Suppressed = suppressed
+			return
 		}
-		if !node.IsActive(*firstInactiveAgent) {
-			log.Printf("Agent %s is not active", firstInactiveAgent.ServerName)
-		} else {
-			s.registerAgent(*firstInactiveAgent)
+		log.Printf("Scaling up from %d to %d agents (%s)...", current, desired, reason)
+		s.setDesired(desired)
+		s.upBreaches = 0
+		s.lastScaleUp = time.Now()
+	case wanted < current:
+		s.downBreaches++
+		s.upBreaches = 0
+		if suppressed := s.scaleDownSuppressed(desired); suppressed != "" {
+			log.Printf("Scale-down wanted (%s), not scaling down: %s", reason, suppressed)
+			span.SetAttributes(attribute.String("suppressed", suppressed))
+			st.Suppressed = suppressed
+			return
 		}
+		log.Printf("Scaling down from %d to %d agents (%s)...", current, desired, reason)
+		s.setDesired(desired)
+		s.downBreaches = 0
+		s.lastScaleDown = time.Now()
+	default:
+		s.upBreaches, s.downBreaches = 0, 0
 	}
 }
 
-func (s *ScalerEngine) registerAgent(agent config.AgentConfig) {
-	if err := deploy.DeployPluggableAPI(agent); err != nil {
-		log.Printf("Error deploying pluggable API to %s: %v", agent.ServerName, err)
+// recordLeadTime folds one measured power-on-to-upstream time into the lead
+// time the predictive policy forecasts over.
+func (s *ScalerEngine) recordLeadTime(agent string, d time.Duration) {
+	if s.leadTime == 0 {
+		s.leadTime = d
 	} else {
-		s.ActiveAgents = append(s.ActiveAgents, agent)
-		log.Printf("Successfully deployed pluggable API to %s", agent.ServerName)
-		if err := node.UpdateUpstreamConfig(s.ActiveAgents); err != nil {
-			log.Printf("Error updating upstream config: %v", err)
-		} else {
-			log.Printf("Successfully updated upstream config")
-		}
+		s.leadTime = (s.leadTime + d) / 2
 	}
+	log.Printf("Agent %s took %s from power-on to the upstream, lead time is now %s", agent, d.Round(time.Second), s.leadTime.Round(time.Second))
 }
 
-func (s *ScalerEngine) CheckAndScaleDown() {
-	s.mu.Lock()
-	if s.isScaling {
-		s.mu.Unlock()
-		return
+// snapshot collects the metrics of the active agents and records their
+// averages in the history. It reports false when no agent returned metrics.
+func (s *ScalerEngine) snapshot(ctx context.Context) (policy.Snapshot, bool) {
+	snap := policy.Snapshot{Time: time.Now(), LeadTime: s.leadTime}
+	if snap.LeadTime == 0 {
+		snap.LeadTime = s.Config.Scaling.Predictive.LeadTime
 	}
-	s.isScaling = true
-	s.mu.Unlock()
 
-	defer func() {
-		s.mu.Lock()
-		s.isScaling = false
-		s.mu.Unlock()
-	}()
+	for _, ag := range s.ActiveAgents {
+		snap.Active = append(snap.Active, ag.ServerName)
 
-	currentActiveAgents := []config.AgentConfig{}
-	for _, ag := range s.Config.AvailableAgents {
-		if node.IsActive(ag) {
-			currentActiveAgents = append(currentActiveAgents, ag)
+		cpu, mem, err := s.agentMetrics(ctx, ag)
+		if err != nil {
+			if os.Getenv("DEBUG") == "true" {
+				log.Printf("Error getting metrics for %s: %v", ag.ServerName, err)
+			}
+			continue
 		}
+		snap.Metrics = append(snap.Metrics, policy.AgentMetrics{Agent: ag.ServerName, CPU: cpu, Memory: mem})
+		snap.CPU += cpu
+		snap.Memory += mem
 	}
 
-	s.ActiveAgents = currentActiveAgents
+	if len(snap.Metrics) < 1 {
+		return snap, false
+	}
+	snap.CPU /= float64(len(snap.Metrics))
+	snap.Memory /= float64(len(snap.Metrics))
 
-	if len(currentActiveAgents) > 1 {
-		log.Println("Low load detected, scaling down...")
-		agentToScaleDown := currentActiveAgents[len(currentActiveAgents)-1]
+	s.history = append(s.history, policy.Sample{Time: snap.Time, Agents: len(snap.Active), CPU: snap.CPU, Memory: snap.Memory})
+	if limit := s.historyLimit(); len(s.history) > limit {
+		s.history = s.history[len(s.history)-limit:]
+	}
+	if err := s.saveHistory(); err != nil {
+		log.Printf("Error saving metrics history to %s: %v", s.Config.Scaling.HistoryFile, err)
+	}
+	snap.History = s.history
+	return snap, true
+}
 
-		if err := node.ManagePower(s.Config.ServerManagerAPI, agentToScaleDown.ServerName, "off"); err != nil {
-			log.Printf("Error stopping agent %s: %v", agentToScaleDown.ServerName, err)
-		}
-		if node.IsActive(agentToScaleDown) {
-			log.Printf("Agent %s is still active", agentToScaleDown.ServerName)
-		} else {
-			updatedActiveAgents := currentActiveAgents[:len(currentActiveAgents)-1]
-			s.ActiveAgents = updatedActiveAgents
+// countStarting counts the agents still starting towards what a policy wants.
+// Policies size the agents serving traffic, so a count between the serving
+// and the desired agents is already on its way and changes nothing; only a
+// count below the serving agents scales down.
+func countStarting(wanted, serving, desired int) int {
+	if wanted >= serving && wanted < desired {
+		return desired
+	}
+	return wanted
+}
 
-			log.Printf("Successfully stopped agent %s", agentToScaleDown.ServerName)
-			if err := node.UpdateUpstreamConfig(s.ActiveAgents); err != nil {
-				log.Printf("Error updating upstream config: %v", err)
-			}
+// clampStep bounds wanted to min_agents and max_agents and to at most
+// max_step agents away from current.
+func (s *ScalerEngine) clampStep(wanted, current int) int {
+	step := s.Config.Scaling.MaxStep
+	desired := min(max(wanted, s.minAgents), s.maxAgents)
+	return min(max(desired, current-step), current+step)
+}
+
+// updateBounds applies the schedules active at now to min_agents and
+// max_agents, logging whenever the set of active schedules changes.
+func (s *ScalerEngine) updateBounds(now time.Time) {
+	minAgents, maxAgents, active := s.Config.Scaling.Bounds(now)
+	if strings.Join(active, ",") != strings.Join(s.schedules, ",") || minAgents != s.minAgents || maxAgents != s.maxAgents {
+		if len(active) > 0 {
+			log.Printf("Schedules %s active: min_agents %d, max_agents %d", strings.Join(active, ", "), minAgents, maxAgents)
+		} else {
+			log.Printf("No schedule active: min_agents %d, max_agents %d", minAgents, maxAgents)
 		}
 	}
+	s.minAgents, s.maxAgents, s.schedules = minAgents, maxAgents, active
 }
 
-func (s *ScalerEngine) EvaluateScaling() {
-	s.mu.Lock()
-	if s.isScaling {
-		s.mu.Unlock()
-		return
+// scaleUpSuppressed returns why a scale-up to desired agents is not allowed
+// yet, or "".
+func (s *ScalerEngine) scaleUpSuppressed(desired int) string {
+	scaling := s.Config.Scaling
+	if desired <= s.desiredCount() {
+		return fmt.Sprintf("already at max_agents (%d)", s.maxAgents)
+	}
+	if s.upBreaches < scaling.ScaleUpBreaches {
+		return fmt.Sprintf("%d of %d consecutive evaluations", s.upBreaches, scaling.ScaleUpBreaches)
 	}
-	s.mu.Unlock()
+	if left := scaling.ScaleUpCooldown - time.Since(s.lastScaleUp); left > 0 {
+		return fmt.Sprintf("scale-up cooldown, %s left", roundUp(left))
+	}
+	return ""
+}
 
-	if len(s.ActiveAgents) < 1 {
-		s.CheckAndScaleUp()
-		return
+// scaleDownSuppressed returns why a scale-down to desired agents is not
+// allowed yet, or "".
+func (s *ScalerEngine) scaleDownSuppressed(desired int) string {
+	scaling := s.Config.Scaling
+	if desired >= s.desiredCount() {
+		return fmt.Sprintf("already at min_agents (%d)", s.minAgents)
+	}
+	if s.downBreaches < scaling.ScaleDownBreaches {
+		return fmt.Sprintf("%d of %d consecutive evaluations", s.downBreaches, scaling.ScaleDownBreaches)
+	}
+	if left := scaling.ScaleDownProtection - time.Since(s.lastScaleUp); left > 0 {
+		return fmt.Sprintf("scale-down protection after the last scale-up, %s left", roundUp(left))
 	}
+	if left := scaling.ScaleDownCooldown - time.Since(s.lastScaleDown); left > 0 {
+		return fmt.Sprintf("scale-down cooldown, %s left", roundUp(left))
+	}
+	return ""
+}
 
-	var totalCPU float64
-	var totalMem float64
-	var activeCount int
+// verifyAgent checks that the agent's info_url, which validation ties to the
+// host and port of its telemetry_url, is served by the expected host and that
+// no other agent reported the same machine ID, which would mean two
+// configured agents are port forwarded to the same VM. Agents without an
+// info_url are not verified and return no info.
+func (s *ScalerEngine) verifyAgent(ctx context.Context, agent config.AgentConfig) (*node.AgentInfo, error) {
+	if agent.InfoURL == "" {
+		return nil, nil
+	}
 
-	for _, ag := range s.ActiveAgents {
-		cpu, mem, err := node.GetMetrics(ag)
-		if err != nil {
-			if os.Getenv("DEBUG") == "true" {
-				log.Printf("Error getting metrics for %s: %v", ag.ServerName, err)
-			}
-			continue
-		}
-		totalCPU += cpu
-		totalMem += mem
-		activeCount++
+	info, err := node.GetInfo(ctx, agent)
+	if err != nil {
+		return nil, err
+	}
+	if err := node.VerifyIdentity(agent, info); err != nil {
+		return nil, err
 	}
 
-	if activeCount < 1 {
-		return
+	if other, ok := s.machineIDs[info.MachineID]; ok && other != agent.ServerName {
+		return nil, fmt.Errorf("machine id %s is already reported by agent %s", info.MachineID, other)
+	}
+	for id, name := range s.machineIDs {
+		if name == agent.ServerName && id != info.MachineID {
+			log.Printf("Agent %s now reports machine id %s (was %s)", agent.ServerName, info.MachineID, id)
+			delete(s.machineIDs, id)
+		}
 	}
+	s.machineIDs[info.MachineID] = agent.ServerName
 
-	avgCPU := totalCPU / float64(activeCount)
-	avgMem := totalMem / float64(activeCount)
-	log.Printf("Average CPU Utilization: %.2f%%, Average Memory Utilization: %.2f%%", avgCPU, avgMem)
+	log.Printf("Verified agent %s: host %s, machine id %s, pluggable API revision %s", agent.ServerName, info.Hostname, info.MachineID, info.PluggableAPIRevision)
+	return info, nil
+}
 
-	if avgCPU > 80 || avgMem > 80 {
-		s.CheckAndScaleUp()
-	} else if avgCPU < 20 && avgMem < 20 {
-		s.CheckAndScaleDown()
+// agentMetrics prefers a fresh pushed snapshot and falls back to pulling the
+// agent's telemetry URL.
+func (s *ScalerEngine) agentMetrics(ctx context.Context, agent config.AgentConfig) (float64, float64, error) {
+	if s.Telemetry != nil {
+		if snap, ok := s.Telemetry.Fresh(agent.ServerName); ok {
+			if snap.Metrics.Error != "" {
+				return 0, 0, fmt.Errorf("metrics api error: %s", snap.Metrics.Error)
+			}
+			return snap.Metrics.CpuUtilizationPercent, snap.Metrics.MemoryUtilizationPercent, nil
+		}
 	}
+	return node.GetMetrics(ctx, agent)
+}
+
+// roundUp rounds d up to whole seconds for log messages, so a wait that is
+// still pending never shows as 0s.
+func roundUp(d time.Duration) time.Duration {
+	return (d + time.Second - 1).Truncate(time.Second)
 }
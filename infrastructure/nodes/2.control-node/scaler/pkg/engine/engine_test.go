@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"context"
+	"testing"
+
+	"scaler/pkg/config"
+	"scaler/pkg/policy"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestCountStarting(t *testing.T) {
+	tests := []struct {
+		name                     string
+		wanted, serving, desired int
+		want                     int
+	}{
+		{"nothing starting, scale up", 3, 2, 2, 3},
+		{"nothing starting, scale down", 1, 2, 2, 1},
+		{"starting agent covers the scale-up", 3, 2, 3, 3},
+		{"unchanged serving count keeps starting agents", 2, 2, 3, 3},
+		{"more than on its way", 5, 2, 3, 5},
+		{"below serving scales down", 1, 2, 3, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countStarting(tt.wanted, tt.serving, tt.desired); got != tt.want {
+				t.Errorf("countStarting(%d, %d, %d) = %d, want %d", tt.wanted, tt.serving, tt.desired, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPoliciesWithAgentsStarting runs the policies with two agents serving
+// and more desired, as while agents are still booting or deploying. More load
+// must never ask for fewer agents than are already on their way.
+func TestPoliciesWithAgentsStarting(t *testing.T) {
+	target := policy.TargetTracking{CPU: 60, Tolerance: 0.1}
+	threshold := policy.Threshold{Up: config.Thresholds{CPU: 80, Memory: 80}, Down: config.Thresholds{CPU: 20, Memory: 20}}
+	step := policy.Step{Metric: "cpu", ScaleUp: []config.StepBand{{Threshold: 70, Agents: 1}, {Threshold: 85, Agents: 3}}}
+
+	tests := []struct {
+		name    string
+		policy  policy.Policy
+		desired int
+		cpu     float64
+		want    int
+	}{
+		{"target on target", target, 4, 60, 4},
+		{"target upper band edge", target, 4, 65.9, 4},
+		{"target above band", target, 4, 66.1, 4},
+		{"target one and a half times", target, 4, 90, 4},
+		{"target twice", target, 4, 120, 4},
+		{"target beyond starting agents", target, 4, 121, 5},
+		{"target lower band edge", target, 4, 54.1, 4},
+		{"target half", target, 4, 29, 1},
+		{"threshold within", threshold, 3, 50, 3},
+		{"threshold above while one starts", threshold, 3, 90, 3},
+		{"threshold below", threshold, 3, 10, 1},
+		{"step small band while one starts", step, 3, 75, 3},
+		{"step large band while one starts", step, 3, 90, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snap := policy.Snapshot{Active: []string{"agent-1", "agent-2"}, CPU: tt.cpu, Memory: tt.cpu}
+			if got := countStarting(tt.policy.Decide(snap).Desired, len(snap.Active), tt.desired); got != tt.want {
+				t.Errorf("cpu %.1f with %d desired: got %d agents, want %d", tt.cpu, tt.desired, got, tt.want)
+			}
+		})
+	}
+}
+
+// newTestEngine returns an engine managing the named agents, all off and not
+// desired, bounded to minAgents and maxAgents.
+func newTestEngine(minAgents, maxAgents int, names ...string) *ScalerEngine {
+	s := &ScalerEngine{
+		ActiveAgents: []config.AgentConfig{},
+		machineIDs:   make(map[string]string),
+		agents:       make(map[string]*agentState),
+		minAgents:    minAgents,
+		maxAgents:    maxAgents,
+	}
+	for _, name := range names {
+		s.Config.AvailableAgents = append(s.Config.AvailableAgents, config.AgentConfig{ServerName: name})
+	}
+	return s
+}
+
+func TestDecideBelowMinAgents(t *testing.T) {
+	tests := []struct {
+		name      string
+		desired   map[string]State
+		wantAdded bool
+	}{
+		{"adds an agent", map[string]State{"agent-1": StateReady}, true},
+		{"only failed agents left", map[string]State{"agent-1": StateReady, "agent-2": StateFailed}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestEngine(2, 2, "agent-1", "agent-2")
+			for name, state := range tt.desired {
+				st := s.agent(name)
+				st.desired, st.state = true, state
+			}
+
+			ctx := context.Background()
+			var st Status
+			s.decide(ctx, trace.SpanFromContext(ctx), &st)
+
+			if added := !s.lastScaleUp.IsZero(); added != tt.wantAdded {
+				t.Errorf("lastScaleUp set = %v, want %v", added, tt.wantAdded)
+			}
+			if got := s.desiredTotal(); got != 2 {
+				t.Errorf("desiredTotal() = %d, want 2", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"scaler/pkg/policy"
+)
+
+// historyFile is the on-disk form of the metrics history.
+type historyFile struct {
+	LeadTimeSeconds float64         `json:"lead_time_seconds,omitempty"`
+	Samples         []policy.Sample `json:"samples"`
+}
+
+// historyLimit is the number of evaluations kept: an hour at the default
+// interval, or the whole predictive window if that is longer.
+func (s *ScalerEngine) historyLimit() int {
+	scaling := s.Config.Scaling
+	return max(maxHistory, int(scaling.Predictive.Window/scaling.Interval)+1)
+}
+
+// loadHistory restores the history and measured lead time saved by an earlier
+// run. A missing file is not an error.
+func (s *ScalerEngine) loadHistory() error {
+	path := s.Config.Scaling.HistoryFile
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var h historyFile
+	if err := json.Unmarshal(data, &h); err != nil {
+		return err
+	}
+	if limit := s.historyLimit(); len(h.Samples) > limit {
+		h.Samples = h.Samples[len(h.Samples)-limit:]
+	}
+	s.history = h.Samples
+	s.leadTime = time.Duration(h.LeadTimeSeconds * float64(time.Second))
+	return nil
+}
+
+// saveHistory writes the history to the history file.
+func (s *ScalerEngine) saveHistory() error {
+	path := s.Config.Scaling.HistoryFile
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(historyFile{
+		LeadTimeSeconds: s.leadTime.Seconds(),
+		Samples:         s.history,
+	})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic writes data through a temporary file and a rename, so a
+// crash never leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	// Flush the data before the rename, or a crash can leave an empty file
+	// under the final name.
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
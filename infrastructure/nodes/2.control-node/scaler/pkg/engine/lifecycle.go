@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"log"
+	"slices"
+	"time"
+)
+
+// State is where an agent is in its lifecycle, from powered off to serving
+// traffic and back.
+type State string
+
+const (
+	StateOff         State = "off"
+	StatePoweringOn  State = "powering_on"
+	StateBooting     State = "booting"
+	StateDeploying   State = "deploying"
+	StateReady       State = "ready"
+	StateDraining    State = "draining"
+	StatePoweringOff State = "powering_off"
+	StateFailed      State = "failed"
+)
+
+// transitions lists the states each state may move to. Any state whose VM is
+// running may drop to off when the server manager reports the VM stopped.
+var transitions = map[State][]State{
+	StateOff:         {StatePoweringOn, StateBooting},
+	StatePoweringOn:  {StateBooting, StateFailed},
+	StateBooting:     {StateDeploying, StateDraining, StatePoweringOff, StateFailed, StateOff},
+	StateDeploying:   {StateReady, StateFailed, StateOff},
+	StateReady:       {StateDraining, StateFailed, StateOff},
+	StateDraining:    {StateReady, StateBooting, StatePoweringOff, StateOff},
+	StatePoweringOff: {StateOff, StateFailed},
+	StateFailed:      {StatePoweringOn, StateBooting, StatePoweringOff, StateOff},
+}
+
+// inFlight reports whether an agent in this state is on its way to ready.
+func (st State) inFlight() bool {
+	return st == StatePoweringOn || st == StateBooting || st == StateDeploying
+}
+
+// transition moves the agent to the state to, logging the change. A
+// transition the lifecycle does not allow is logged and ignored, and false is
+// returned.
+func (s *ScalerEngine) transition(name string, st *agentState, to State) bool {
+	if st.state == to {
+		return true
+	}
+	if !slices.Contains(transitions[st.state], to) {
+		log.Printf("Error: agent %s cannot move from %s to %s", name, st.state, to)
+		return false
+	}
+	log.Printf("Agent %s: %s -> %s", name, st.state, to)
+	if st.state == StateDraining {
+		st.drainStart = time.Time{}
+		st.connections, st.jobs = 0, 0
+	}
+	st.state = to
+	st.since = time.Now()
+	return true
+}
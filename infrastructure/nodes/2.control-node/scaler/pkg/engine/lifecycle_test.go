@@ -0,0 +1,66 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTransition(t *testing.T) {
+	tests := []struct {
+		from, to State
+		ok       bool
+	}{
+		{StateOff, StatePoweringOn, true},
+		{StateOff, StateBooting, true},
+		{StateOff, StateReady, false},
+		{StatePoweringOn, StateBooting, true},
+		{StatePoweringOn, StateOff, false},
+		{StateBooting, StateDeploying, true},
+		{StateBooting, StateReady, false},
+		{StateBooting, StateDraining, true},
+		{StateDeploying, StateReady, true},
+		{StateDeploying, StateDraining, false},
+		{StateReady, StateDraining, true},
+		{StateReady, StatePoweringOff, false},
+		{StateDraining, StateReady, true},
+		{StateDraining, StatePoweringOff, true},
+		{StateDraining, StateBooting, true},
+		{StatePoweringOff, StateOff, true},
+		{StatePoweringOff, StateReady, false},
+		{StateFailed, StatePoweringOn, true},
+		{StateFailed, StateReady, false},
+		{StateReady, StateReady, true},
+	}
+	var s ScalerEngine
+	for _, tt := range tests {
+		t.Run(string(tt.from)+"->"+string(tt.to), func(t *testing.T) {
+			since := time.Now().Add(-time.Hour)
+			st := &agentState{state: tt.from, since: since}
+			if got := s.transition("agent-1", st, tt.to); got != tt.ok {
+				t.Fatalf("transition() = %v, want %v", got, tt.ok)
+			}
+			want := tt.to
+			if !tt.ok {
+				want = tt.from
+			}
+			if st.state != want {
+				t.Errorf("state = %s, want %s", st.state, want)
+			}
+			if moved := tt.ok && tt.from != tt.to; moved == st.since.Equal(since) {
+				t.Errorf("since changed = %v, want %v", !st.since.Equal(since), moved)
+			}
+		})
+	}
+}
+
+// TestTransitionsAreKnown checks that every target state has transitions of
+// its own, so no agent can get stuck in a state the lifecycle never leaves.
+func TestTransitionsAreKnown(t *testing.T) {
+	for from, targets := range transitions {
+		for _, to := range targets {
+			if _, ok := transitions[to]; !ok {
+				t.Errorf("%s may move to %s, which has no transitions", from, to)
+			}
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	"scaler/pkg/config"
+	"scaler/pkg/deploy"
+	"scaler/pkg/node"
+	"scaler/pkg/observability"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// agentState is what the reconciler has observed and done for one configured
+// agent.
+type agentState struct {
+	desired bool
+	state   State
+	// since is when the agent entered state.
+	since time.Time
+	// powered is the VM state last reported by the server manager.
+	powered bool
+	// poweredOnAt is when the scaler powered the agent on, cleared once it
+	// joins the upstream.
+	poweredOnAt time.Time
+	// drainStart is when a draining agent was first seen out of the
+	// upstream, and connections and jobs are what it still had open at the
+	// last check.
+	drainStart  time.Time
+	connections int
+	jobs        int
+	// ref is the deploy.ref the agent was last deployed with and revision
+	// the pluggable API revision its info_url reported afterwards.
+	ref      string
+	revision string
+
+	failures  int
+	retryAt   time.Time
+	lastErr   string
+	lastErrAt time.Time
+}
+
+const (
+	retryBase = 10 * time.Second
+	retryMax  = 5 * time.Minute
+	// bootTimeout is how long an agent the scaler powered on may stay
+	// unreachable over SSH before that counts as a failure.
+	bootTimeout = 5 * time.Minute
+)
+
+var (
+	errNotReachable = errors.New("not reachable over SSH")
+	errNotReady     = errors.New("not ready")
+)
+
+// observe refreshes the power and readiness of every configured agent and
+// moves agents whose VM started or stopped behind the scaler's back. On the
+// first successful observation the agents that are already running become
+// the desired set, so a restarted scaler adopts them instead of powering them
+// off. It reports false when the server manager could not be reached.
+func (s *ScalerEngine) observe(ctx context.Context) bool {
+	states, err := node.PowerStates(ctx, s.Config.ServerManagerAPI, s.Config.ServerManagerToken)
+	if err != nil {
+		log.Printf("Error getting power states from the server manager: %v", err)
+		return false
+	}
+
+	for _, ag := range s.Config.AvailableAgents {
+		st := s.agent(ag.ServerName)
+		state, ok := states[ag.ServerName]
+		if !ok || state == "unknown" {
+			continue
+		}
+		st.powered = state == "running"
+
+		switch {
+		case !st.powered && st.state != StateOff && st.state != StateFailed:
+			log.Printf("Agent %s is %s", ag.ServerName, state)
+			s.transition(ag.ServerName, st, StateOff)
+		case st.powered && st.state == StateOff:
+			s.transition(ag.ServerName, st, StateBooting)
+		case st.state == StateReady && !node.IsReady(ctx, ag):
+			s.fail(ag.ServerName, st, "probing", errNotReady)
+		}
+	}
+
+	if !s.adopted {
+		s.adopted = true
+		for _, ag := range s.Config.AvailableAgents {
+			st := s.agent(ag.ServerName)
+			if !st.powered || s.desiredCount() >= s.maxAgents {
+				continue
+			}
+			st.desired = true
+			if st.state == StateBooting && s.servingAlready(ctx, ag) {
+				// Adopting restores what the scaler knew before the
+				// restart rather than taking a lifecycle step, so the
+				// agent is not deployed again.
+				st.state, st.since = StateReady, time.Now()
+				log.Printf("Agent %s is already running and in the upstream, adopting it as ready", ag.ServerName)
+				continue
+			}
+			log.Printf("Agent %s is already running, adopting it", ag.ServerName)
+		}
+	}
+	return true
+}
+
+// servingAlready reports whether a running agent found without saved state is
+// in the current upstream and passes the ready_url and info_url checks, so it
+// can keep serving without a deploy.
+func (s *ScalerEngine) servingAlready(ctx context.Context, agent config.AgentConfig) bool {
+	if !s.inUpstream(agent.ServerName) || !node.IsReady(ctx, agent) {
+		return false
+	}
+	info, err := s.verifyAgent(ctx, agent)
+	if err != nil {
+		log.Printf("Error verifying agent %s, deploying it again: %v", agent.ServerName, err)
+		return false
+	}
+	if info != nil {
+		s.agent(agent.ServerName).revision = info.PluggableAPIRevision
+	}
+	return true
+}
+
+// reconcile takes one step towards the desired set: agents that are no longer
+// wanted drain out of the upstream before they are powered off, and wanted
+// agents are powered on, deployed and added to the upstream. Each failed step
+// is retried with backoff on a later loop, so a partial failure heals itself.
+func (s *ScalerEngine) reconcile(ctx context.Context) {
+	ctx, span := observability.Tracer.Start(ctx, "scaler.reconcile")
+	defer span.End()
+	span.SetAttributes(attribute.Int("agents.desired", s.desiredCount()))
+
+	s.syncUpstream(ctx)
+
+	now := time.Now()
+	for _, ag := range s.Config.AvailableAgents {
+		st := s.agent(ag.ServerName)
+
+		switch {
+		case st.state == StateOff && st.desired:
+			s.powerOn(ctx, ag, st)
+		case st.state == StateBooting && st.desired:
+			s.deployAgent(ctx, ag, st)
+		case st.state == StateBooting && s.inUpstream(ag.ServerName):
+			// An adopted agent that is still in the upstream may be serving
+			// traffic, so it drains like a ready one.
+			s.transition(ag.ServerName, st, StateDraining)
+		case st.state == StateBooting:
+			s.powerOff(ctx, ag, st)
+		case st.state == StateReady && !st.desired:
+			s.transition(ag.ServerName, st, StateDraining)
+		case st.state == StateDraining && st.desired && node.IsReady(ctx, ag):
+			s.transition(ag.ServerName, st, StateReady)
+		case st.state == StateDraining && st.desired:
+			// It drained out of booting and was never deployed.
+			s.transition(ag.ServerName, st, StateBooting)
+		case st.state == StateDraining && !s.inUpstream(ag.ServerName) && s.drained(ctx, ag, st):
+			s.powerOff(ctx, ag, st)
+		case st.state == StateFailed && !now.Before(st.retryAt):
+			s.retry(ctx, ag, st)
+		}
+	}
+
+	s.syncUpstream(ctx)
+}
+
+// retry picks a failed agent up again from its observed power state.
+func (s *ScalerEngine) retry(ctx context.Context, agent config.AgentConfig, st *agentState) {
+	switch {
+	case st.desired && st.powered:
+		if s.transition(agent.ServerName, st, StateBooting) {
+			s.deployAgent(ctx, agent, st)
+		}
+	case st.desired:
+		s.powerOn(ctx, agent, st)
+	case st.powered && !s.inUpstream(agent.ServerName):
+		s.powerOff(ctx, agent, st)
+	case !st.powered:
+		s.transition(agent.ServerName, st, StateOff)
+		s.succeed(st)
+	}
+}
+
+func (s *ScalerEngine) powerOn(ctx context.Context, agent config.AgentConfig, st *agentState) {
+	if !s.transition(agent.ServerName, st, StatePoweringOn) {
+		return
+	}
+	if err := node.ManagePower(ctx, s.Config.ServerManagerAPI, s.Config.ServerManagerToken, agent.ServerName, "on"); err != nil {
+		scaleCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("direction", "up"), attribute.Bool("success", false)))
+		s.fail(agent.ServerName, st, "starting", err)
+		return
+	}
+	scaleCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("direction", "up"), attribute.Bool("success", true)))
+	st.powered = true
+	if st.poweredOnAt.IsZero() {
+		st.poweredOnAt = time.Now()
+	}
+	s.transition(agent.ServerName, st, StateBooting)
+
+	// The agent usually boots within one SSH connect timeout, so carry on
+	// with the deploy in the same loop.
+	s.deployAgent(ctx, agent, st)
+}
+
+func (s *ScalerEngine) deployAgent(ctx context.Context, agent config.AgentConfig, st *agentState) {
+	if !node.IsActive(ctx, s.Config.SSH, agent) {
+		if !st.poweredOnAt.IsZero() && time.Since(st.poweredOnAt) < bootTimeout {
+			return
+		}
+		s.fail(agent.ServerName, st, "reaching", errNotReachable)
+		return
+	}
+
+	s.transition(agent.ServerName, st, StateDeploying)
+	if err := deploy.DeployPluggableAPI(ctx, s.Config.Deploy, s.Config.SSH, agent); err != nil {
+		s.fail(agent.ServerName, st, "deploying the pluggable API to", err)
+		return
+	}
+	if !node.WaitReady(ctx, agent, 2*time.Minute) {
+		s.fail(agent.ServerName, st, "waiting for", errNotReady)
+		return
+	}
+	info, err := s.verifyAgent(ctx, agent)
+	if err != nil {
+		s.fail(agent.ServerName, st, "verifying", err)
+		return
+	}
+	log.Printf("Successfully deployed pluggable API to %s", agent.ServerName)
+	st.ref = s.Config.Deploy.Ref
+	st.revision = ""
+	if info != nil {
+		st.revision = info.PluggableAPIRevision
+	}
+	s.transition(agent.ServerName, st, StateReady)
+	s.succeed(st)
+}
+
+// drained reports whether a draining agent may be powered off: nginx holds no
+// connections to it and it runs no jobs, or drain_timeout has passed since it
+// left the upstream.
+func (s *ScalerEngine) drained(ctx context.Context, agent config.AgentConfig, st *agentState) bool {
+	if st.drainStart.IsZero() {
+		st.drainStart = time.Now()
+	}
+	waited := time.Since(st.drainStart)
+
+	connections, err := node.UpstreamConnections(ctx, agent)
+	var jobs int
+	if err == nil {
+		jobs, err = node.InFlightJobs(ctx, agent)
+	}
+	st.connections, st.jobs = connections, jobs
+
+	switch {
+	case err == nil && connections == 0 && jobs == 0:
+		log.Printf("Agent %s drained after %s", agent.ServerName, roundUp(waited))
+		return true
+	case waited >= s.Config.Scaling.DrainTimeout && err != nil:
+		log.Printf("Agent %s reached drain_timeout (%s) without a drain check, powering off: %v", agent.ServerName, s.Config.Scaling.DrainTimeout, err)
+		return true
+	case waited >= s.Config.Scaling.DrainTimeout:
+		log.Printf("Agent %s still has %d connections and %d jobs after drain_timeout (%s), powering off", agent.ServerName, connections, jobs, s.Config.Scaling.DrainTimeout)
+		return true
+	case err != nil:
+		log.Printf("Error checking whether agent %s is drained: %v", agent.ServerName, err)
+	default:
+		log.Printf("Agent %s draining: %d connections, %d jobs, %s left", agent.ServerName, connections, jobs, roundUp(s.Config.Scaling.DrainTimeout-waited))
+	}
+	return false
+}
+
+func (s *ScalerEngine) powerOff(ctx context.Context, agent config.AgentConfig, st *agentState) {
+	if !s.transition(agent.ServerName, st, StatePoweringOff) {
+		return
+	}
+	if err := node.ManagePower(ctx, s.Config.ServerManagerAPI, s.Config.ServerManagerToken, agent.ServerName, "off"); err != nil {
+		scaleCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("direction", "down"), attribute.Bool("success", false)))
+		s.fail(agent.ServerName, st, "stopping", err)
+		return
+	}
+	scaleCounter.Add(ctx, 1, metric.WithAttributes(attribute.String("direction", "down"), attribute.Bool("success", true)))
+	log.Printf("Successfully stopped agent %s", agent.ServerName)
+	st.powered = false
+	st.poweredOnAt = time.Time{}
+	s.transition(agent.ServerName, st, StateOff)
+	s.succeed(st)
+}
+
+// syncUpstream writes the upstream when the agents that should serve, the
+// desired agents that are ready, differ from the last upstream written. While
+// no agent is ready the current upstream is kept, since nginx rejects an
+// empty one. A failed write is retried on the next loop.
+func (s *ScalerEngine) syncUpstream(ctx context.Context) {
+	var serving []config.AgentConfig
+	var names []string
+	for _, ag := range s.Config.AvailableAgents {
+		if st := s.agent(ag.ServerName); st.desired && st.state == StateReady {
+			serving = append(serving, ag)
+			names = append(names, ag.ServerName)
+		}
+	}
+	if len(serving) == 0 || s.upstreamSynced && slices.Equal(names, s.upstream) {
+		return
+	}
+
+	if err := node.UpdateUpstreamConfig(ctx, s.Config.LoadBalancer, serving); err != nil {
+		log.Printf("Error updating upstream config: %v", err)
+		return
+	}
+	log.Printf("Successfully updated upstream config")
+
+	for _, ag := range serving {
+		if st := s.agent(ag.ServerName); !st.poweredOnAt.IsZero() {
+			s.recordLeadTime(ag.ServerName, time.Since(st.poweredOnAt))
+			st.poweredOnAt = time.Time{}
+		}
+	}
+	s.upstream = names
+	s.upstreamSynced = true
+	s.ActiveAgents = serving
+	if s.ActiveAgents == nil {
+		s.ActiveAgents = []config.AgentConfig{}
+	}
+}
+
+// setDesired grows or shrinks the desired set until n agents that have not
+// failed are desired, so agents that are still on their way to ready count
+// and failed ones do not. Growing prefers agents that are already running and
+// then follows the configuration order. A failed agent is only added back
+// while max_agents leaves room for it. Shrinking drops failed agents first,
+// then agents that are not serving yet, then serving ones, last configured
+// first.
+func (s *ScalerEngine) setDesired(n int) {
+	agents := s.Config.AvailableAgents
+
+	if s.desiredCount() > n {
+		for _, drop := range []func(string, *agentState) bool{
+			func(_ string, st *agentState) bool { return st.state == StateFailed },
+			func(name string, _ *agentState) bool { return !s.inUpstream(name) },
+			func(string, *agentState) bool { return true },
+		} {
+			for i := len(agents) - 1; i >= 0; i-- {
+				if s.desiredCount() <= n {
+					return
+				}
+				if st := s.agent(agents[i].ServerName); st.desired && drop(agents[i].ServerName, st) {
+					st.desired = false
+				}
+			}
+		}
+		return
+	}
+
+	for _, pick := range []func(*agentState) bool{
+		func(st *agentState) bool { return st.powered && st.state != StateFailed },
+		func(st *agentState) bool { return st.state != StateFailed },
+		func(st *agentState) bool { return s.desiredTotal() < s.maxAgents },
+	} {
+		for _, ag := range agents {
+			if s.desiredCount() >= n {
+				return
+			}
+			if st := s.agent(ag.ServerName); !st.desired && pick(st) {
+				st.desired = true
+			}
+		}
+	}
+}
+
+// desiredCount returns the number of desired agents that have not failed.
+func (s *ScalerEngine) desiredCount() int {
+	n := 0
+	for _, ag := range s.Config.AvailableAgents {
+		if st := s.agent(ag.ServerName); st.desired && st.state != StateFailed {
+			n++
+		}
+	}
+	return n
+}
+
+// desiredTotal returns the number of desired agents, failed or not.
+func (s *ScalerEngine) desiredTotal() int {
+	n := 0
+	for _, ag := range s.Config.AvailableAgents {
+		if s.agent(ag.ServerName).desired {
+			n++
+		}
+	}
+	return n
+}
+
+// inFlightCount returns the number of desired agents on their way to ready.
+func (s *ScalerEngine) inFlightCount() int {
+	n := 0
+	for _, ag := range s.Config.AvailableAgents {
+		if st := s.agent(ag.ServerName); st.desired && st.state.inFlight() {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *ScalerEngine) inUpstream(name string) bool {
+	return slices.Contains(s.upstream, name)
+}
+
+// agent returns the state of the named agent, creating it as off on first
+// use.
+func (s *ScalerEngine) agent(name string) *agentState {
+	st, ok := s.agents[name]
+	if !ok {
+		st = &agentState{state: StateOff, since: time.Now()}
+		s.agents[name] = st
+	}
+	return st
+}
+
+// fail moves the agent to failed, records the error and schedules the retry,
+// doubling the delay with every consecutive failure up to retryMax.
+func (s *ScalerEngine) fail(name string, st *agentState, action string, err error) {
+	delay := retryBase << min(st.failures, 5)
+	if delay > retryMax {
+		delay = retryMax
+	}
+	st.failures++
+	st.lastErr = err.Error()
+	st.lastErrAt = time.Now()
+	st.retryAt = time.Now().Add(delay)
+	log.Printf("Error %s agent %s (attempt %d, retrying in %s): %v", action, name, st.failures, delay, err)
+	s.transition(name, st, StateFailed)
+}
+
+// succeed resets the backoff once an agent reached the state it was heading
+// for. The last error stays for the status API.
+func (s *ScalerEngine) succeed(st *agentState) {
+	st.failures = 0
+	st.retryAt = time.Time{}
+}
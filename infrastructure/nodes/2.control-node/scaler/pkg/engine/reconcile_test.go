@@ -0,0 +1,110 @@
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// fakeServerManager reports states as the VM state of each server and records
+// the power requests it receives.
+type fakeServerManager struct {
+	states map[string]string
+
+	mu    sync.Mutex
+	power []string
+}
+
+func (f *fakeServerManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/v1/servers/status" {
+		var status struct {
+			Servers []map[string]string `json:"servers"`
+		}
+		for server, state := range f.states {
+			status.Servers = append(status.Servers, map[string]string{"server": server, "state": state})
+		}
+		json.NewEncoder(w).Encode(status)
+		return
+	}
+
+	var req struct {
+		Action string `json:"action"`
+		Server string `json:"server"`
+	}
+	if r.URL.Path != "/api/v1/servers/power" || json.NewDecoder(r.Body).Decode(&req) != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	f.power = append(f.power, req.Action+" "+req.Server)
+	f.mu.Unlock()
+}
+
+func TestObserveAdoptsRunningAgents(t *testing.T) {
+	srv := httptest.NewServer(&fakeServerManager{states: map[string]string{
+		"agent-1": "running",
+		"agent-2": "running",
+		"agent-3": "poweroff",
+	}})
+	defer srv.Close()
+
+	// Without a state file, agent-1 is running and in the upstream read at
+	// startup, agent-2 is running but not in it.
+	s := newTestEngine(1, 3, "agent-1", "agent-2", "agent-3")
+	s.Config.ServerManagerAPI = srv.URL
+	s.upstream = []string{"agent-1"}
+
+	if !s.observe(context.Background()) {
+		t.Fatal("observe() = false, want true")
+	}
+
+	tests := []struct {
+		name    string
+		state   State
+		desired bool
+	}{
+		{"agent-1", StateReady, true},
+		{"agent-2", StateBooting, true},
+		{"agent-3", StateOff, false},
+	}
+	for _, tt := range tests {
+		st := s.agent(tt.name)
+		if st.state != tt.state || st.desired != tt.desired {
+			t.Errorf("%s is %s, desired %v, want %s, desired %v", tt.name, st.state, st.desired, tt.state, tt.desired)
+		}
+	}
+}
+
+func TestReconcileUndesiredBootingAgents(t *testing.T) {
+	sm := &fakeServerManager{}
+	srv := httptest.NewServer(sm)
+	defer srv.Close()
+
+	// After a restart both agents were adopted while booting and neither is
+	// desired. agent-1 is still in the upstream, which is kept while no agent
+	// is ready; agent-2 is not.
+	s := newTestEngine(0, 2, "agent-1", "agent-2")
+	s.Config.ServerManagerAPI = srv.URL
+	s.upstream = []string{"agent-1"}
+	s.upstreamSynced = true
+	for _, name := range []string{"agent-1", "agent-2"} {
+		st := s.agent(name)
+		st.state, st.powered = StateBooting, true
+	}
+
+	s.reconcile(context.Background())
+
+	if got := s.agent("agent-1").state; got != StateDraining {
+		t.Errorf("agent-1 state = %s, want %s", got, StateDraining)
+	}
+	if got := s.agent("agent-2").state; got != StateOff {
+		t.Errorf("agent-2 state = %s, want %s", got, StateOff)
+	}
+	if want := []string{"off agent-2"}; !slices.Equal(sm.power, want) {
+		t.Errorf("power requests = %v, want %v", sm.power, want)
+	}
+}
@@ -0,0 +1,196 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"scaler/pkg/config"
+	"scaler/pkg/node"
+)
+
+// stateFile is the on-disk form of the engine state. The upstream is not part
+// of it; the upstream file itself is read back instead.
+type stateFile struct {
+	SavedAt       time.Time             `json:"saved_at"`
+	LastScaleUp   *time.Time            `json:"last_scale_up,omitempty"`
+	LastScaleDown *time.Time            `json:"last_scale_down,omitempty"`
+	Agents        map[string]savedAgent `json:"agents"`
+}
+
+type savedAgent struct {
+	State       State      `json:"state"`
+	Since       time.Time  `json:"since"`
+	Desired     bool       `json:"desired"`
+	Ref         string     `json:"ref,omitempty"`
+	Revision    string     `json:"revision,omitempty"`
+	MachineID   string     `json:"machine_id,omitempty"`
+	Failures    int        `json:"failures,omitempty"`
+	RetryAt     *time.Time `json:"retry_at,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+}
+
+// loadUpstream takes the agents in the current upstream file as the upstream
+// last written, so a restart neither rewrites nor reloads nginx needlessly.
+// An address that matches no configured agent, such as the placeholder from
+// the load balancer setup, makes the next ready agent rewrite the file.
+func (s *ScalerEngine) loadUpstream() error {
+	servers, err := node.ReadUpstreamConfig(s.Config.LoadBalancer)
+	if err != nil {
+		return err
+	}
+
+	byAddr := make(map[string]config.AgentConfig, len(s.Config.AvailableAgents))
+	for _, ag := range s.Config.AvailableAgents {
+		byAddr[node.UpstreamAddr(ag)] = ag
+	}
+
+	s.upstream = nil
+	s.ActiveAgents = []config.AgentConfig{}
+	known := true
+	for _, addr := range servers {
+		ag, ok := byAddr[addr]
+		if !ok {
+			known = false
+			continue
+		}
+		s.upstream = append(s.upstream, ag.ServerName)
+		s.ActiveAgents = append(s.ActiveAgents, ag)
+	}
+	s.upstreamSynced = known && len(s.upstream) > 0
+	return nil
+}
+
+// loadState restores the agent states and last scale times saved by an
+// earlier run. A missing file is not an error. Steps that were cut short by
+// the restart are set back so they run again, and the first observation then
+// corrects every agent whose VM changed while the scaler was down.
+func (s *ScalerEngine) loadState() error {
+	path := s.Config.StateFile
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var f stateFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+
+	if f.LastScaleUp != nil {
+		s.lastScaleUp = *f.LastScaleUp
+	}
+	if f.LastScaleDown != nil {
+		s.lastScaleDown = *f.LastScaleDown
+	}
+
+	restored := 0
+	for _, ag := range s.Config.AvailableAgents {
+		saved, ok := f.Agents[ag.ServerName]
+		if !ok {
+			continue
+		}
+		if _, ok := transitions[saved.State]; !ok {
+			log.Printf("Agent %s has unknown state %q in %s, treating it as off", ag.ServerName, saved.State, path)
+			saved.State = StateOff
+		}
+
+		st := s.agent(ag.ServerName)
+		st.desired = saved.Desired
+		st.state = saved.State
+		st.since = saved.Since
+		st.ref = saved.Ref
+		st.revision = saved.Revision
+		st.failures = saved.Failures
+		if saved.RetryAt != nil {
+			st.retryAt = *saved.RetryAt
+		}
+		st.lastErr = saved.LastError
+		if saved.LastErrorAt != nil {
+			st.lastErrAt = *saved.LastErrorAt
+		}
+		if saved.MachineID != "" {
+			s.machineIDs[saved.MachineID] = ag.ServerName
+		}
+
+		switch st.state {
+		case StatePoweringOn:
+			st.state = StateOff
+		case StateDeploying:
+			log.Printf("Agent %s was deploying when the scaler stopped, deploying it again", ag.ServerName)
+			st.state = StateBooting
+		case StatePoweringOff:
+			st.state = StateDraining
+		}
+		// A booting agent gets a full boot timeout from now, not from before
+		// the restart, so the downtime does not count against its boot.
+		if st.state == StateBooting {
+			st.poweredOnAt = time.Now()
+		}
+		restored++
+	}
+
+	s.adopted = true
+	log.Printf("Restored the state of %d agents from %s", restored, path)
+	return nil
+}
+
+// saveState writes the engine state to the state file.
+func (s *ScalerEngine) saveState() error {
+	path := s.Config.StateFile
+	if path == "" {
+		return nil
+	}
+
+	machineIDs := make(map[string]string, len(s.machineIDs))
+	for id, name := range s.machineIDs {
+		machineIDs[name] = id
+	}
+
+	f := stateFile{
+		SavedAt:       time.Now().UTC(),
+		LastScaleUp:   optionalTime(s.lastScaleUp),
+		LastScaleDown: optionalTime(s.lastScaleDown),
+		Agents:        make(map[string]savedAgent, len(s.Config.AvailableAgents)),
+	}
+	for _, ag := range s.Config.AvailableAgents {
+		st := s.agent(ag.ServerName)
+		f.Agents[ag.ServerName] = savedAgent{
+			State:       st.state,
+			Since:       st.since.UTC(),
+			Desired:     st.desired,
+			Ref:         st.ref,
+			Revision:    st.revision,
+			MachineID:   machineIDs[ag.ServerName],
+			Failures:    st.failures,
+			RetryAt:     optionalTime(st.retryAt),
+			LastError:   st.lastErr,
+			LastErrorAt: optionalTime(st.lastErrAt),
+		}
+	}
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// optionalTime returns t in UTC, or nil for the zero time.
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"scaler/pkg/config"
+)
+
+func TestSaveAndLoadState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	since := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+
+	saved := newTestEngine(1, 3, "agent-1", "agent-2", "agent-3")
+	saved.Config.StateFile = path
+	saved.lastScaleUp = since.Add(time.Minute)
+	saved.machineIDs["machine-1"] = "agent-1"
+	*saved.agent("agent-1") = agentState{desired: true, state: StateReady, since: since, ref: "v1.2", revision: "abc123"}
+	*saved.agent("agent-2") = agentState{desired: true, state: StateFailed, since: since, failures: 2, retryAt: since.Add(40 * time.Second), lastErr: "not ready", lastErrAt: since}
+	if err := saved.saveState(); err != nil {
+		t.Fatalf("saveState() error = %v", err)
+	}
+
+	s := newTestEngine(1, 3, "agent-1", "agent-2", "agent-3")
+	s.Config.StateFile = path
+	if err := s.loadState(); err != nil {
+		t.Fatalf("loadState() error = %v", err)
+	}
+
+	if !s.adopted {
+		t.Error("adopted = false after loading a state file, want true")
+	}
+	if !s.lastScaleUp.Equal(saved.lastScaleUp) || !s.lastScaleDown.IsZero() {
+		t.Errorf("last scale times = %s, %s, want %s and zero", s.lastScaleUp, s.lastScaleDown, saved.lastScaleUp)
+	}
+	if got := s.machineIDs["machine-1"]; got != "agent-1" {
+		t.Errorf("machine-1 belongs to %q, want agent-1", got)
+	}
+	for _, name := range []string{"agent-1", "agent-2", "agent-3"} {
+		got, want := s.agent(name), saved.agent(name)
+		if got.desired != want.desired || got.state != want.state || !got.since.Equal(want.since) ||
+			got.ref != want.ref || got.revision != want.revision || got.failures != want.failures ||
+			!got.retryAt.Equal(want.retryAt) || got.lastErr != want.lastErr || !got.lastErrAt.Equal(want.lastErrAt) {
+			t.Errorf("%s restored as %+v, want %+v", name, *got, *want)
+		}
+	}
+}
+
+func TestLoadStateNormalizesInterruptedSteps(t *testing.T) {
+	tests := []struct {
+		saved State
+		want  State
+	}{
+		{StateOff, StateOff},
+		{StatePoweringOn, StateOff},
+		{StateBooting, StateBooting},
+		{StateDeploying, StateBooting},
+		{StateReady, StateReady},
+		{StateDraining, StateDraining},
+		{StatePoweringOff, StateDraining},
+		{StateFailed, StateFailed},
+		{"sleeping", StateOff},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.saved), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			data := `{"agents": {"agent-1": {"state": "` + string(tt.saved) + `", "desired": true}, "removed": {"state": "ready"}}}`
+			if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			s := newTestEngine(1, 1, "agent-1")
+			s.Config.StateFile = path
+			before := time.Now()
+			if err := s.loadState(); err != nil {
+				t.Fatalf("loadState() error = %v", err)
+			}
+			st := s.agent("agent-1")
+			if st.state != tt.want {
+				t.Errorf("state = %s, want %s", st.state, tt.want)
+			}
+			// A restored booting agent gets a fresh boot timeout.
+			if booting := tt.want == StateBooting; booting != !st.poweredOnAt.Before(before) {
+				t.Errorf("poweredOnAt = %s, want it reset to now: %v", st.poweredOnAt, booting)
+			}
+			if _, ok := s.agents["removed"]; ok {
+				t.Error("an agent that is no longer configured was restored")
+			}
+		})
+	}
+}
+
+func TestLoadStateWithoutFile(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+	}{
+		{"not configured", ""},
+		{"missing", filepath.Join(t.TempDir(), "state.json")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestEngine(1, 1, "agent-1")
+			s.Config.StateFile = tt.path
+			if err := s.loadState(); err != nil {
+				t.Fatalf("loadState() error = %v", err)
+			}
+			if s.adopted {
+				t.Error("adopted = true without a state file, want false")
+			}
+		})
+	}
+}
+
+func TestLoadUpstream(t *testing.T) {
+	tests := []struct {
+		name       string
+		servers    string
+		want       []string
+		wantSynced bool
+	}{
+		{"configured agents", "server 10.0.0.2:5001;\nserver 10.0.0.1:5001;", []string{"agent-2", "agent-1"}, true},
+		{"setup placeholder", "server 127.0.0.1:65535;", nil, false},
+		{"placeholder and agent", "server 127.0.0.1:65535;\nserver 10.0.0.1:5001;", []string{"agent-1"}, false},
+		{"empty", "", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "upstream.conf")
+			if err := os.WriteFile(path, []byte("upstream backend {\n"+tt.servers+"\n}\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			s := newTestEngine(1, 2, "agent-1", "agent-2")
+			s.Config.LoadBalancer = config.LoadBalancerConfig{UpstreamFile: path}
+			s.Config.AvailableAgents[0].UpstreamURL = "http://10.0.0.1:5001"
+			s.Config.AvailableAgents[1].UpstreamURL = "https://10.0.0.2:5001"
+			if err := s.loadUpstream(); err != nil {
+				t.Fatalf("loadUpstream() error = %v", err)
+			}
+
+			if !slices.Equal(s.upstream, tt.want) || s.upstreamSynced != tt.wantSynced {
+				t.Errorf("upstream = %v, synced %v, want %v, synced %v", s.upstream, s.upstreamSynced, tt.want, tt.wantSynced)
+			}
+			if len(s.ActiveAgents) != len(tt.want) {
+				t.Errorf("%d active agents, want %d", len(s.ActiveAgents), len(tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package engine
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"scaler/pkg/policy"
+)
+
+// Status is the outcome of the latest evaluation, served on /api/v1/status.
+type Status struct {
+	Time            time.Time        `json:"time"`
+	Policy          string           `json:"policy"`
+	ActiveAgents    []string         `json:"active_agents"`
+	MinAgents       int              `json:"min_agents"`
+	MaxAgents       int              `json:"max_agents"`
+	Schedules       []string         `json:"schedules,omitempty"`
+	DesiredAgents   int              `json:"desired_agents"`
+	InFlightAgents  int              `json:"in_flight_agents"`
+	CPU             float64          `json:"cpu_avg"`
+	Memory          float64          `json:"memory_avg"`
+	Decision        string           `json:"decision,omitempty"`
+	Suppressed      string           `json:"suppressed,omitempty"`
+	UpBreaches      int              `json:"scale_up_breaches"`
+	DownBreaches    int              `json:"scale_down_breaches"`
+	LastScaleUp     *time.Time       `json:"last_scale_up,omitempty"`
+	LastScaleDown   *time.Time       `json:"last_scale_down,omitempty"`
+	LeadTimeSeconds float64          `json:"lead_time_seconds"`
+	Forecast        *policy.Forecast `json:"forecast,omitempty"`
+	Agents          []AgentStatus    `json:"agents"`
+}
+
+// AgentStatus is the lifecycle state of one configured agent.
+type AgentStatus struct {
+	Name        string     `json:"name"`
+	State       State      `json:"state"`
+	Since       time.Time  `json:"since"`
+	Desired     bool       `json:"desired"`
+	InUpstream  bool       `json:"in_upstream"`
+	Ref         string     `json:"ref,omitempty"`
+	Revision    string     `json:"revision,omitempty"`
+	Failures    int        `json:"failures,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	RetryAt     *time.Time `json:"retry_at,omitempty"`
+	Drain       *Drain     `json:"drain,omitempty"`
+}
+
+// Drain is the progress of an agent that left the upstream and waits for its
+// connections and jobs to finish.
+type Drain struct {
+	Since             time.Time `json:"since"`
+	ActiveConnections int       `json:"active_connections"`
+	InFlightJobs      int       `json:"in_flight_jobs"`
+}
+
+// publishStatus completes st with the engine state after an evaluation and
+// makes it the status served to readers.
+func (s *ScalerEngine) publishStatus(st *Status) {
+	st.Time = time.Now().UTC()
+	st.Policy = s.policy.Name()
+	st.ActiveAgents = []string{}
+	for _, ag := range s.ActiveAgents {
+		st.ActiveAgents = append(st.ActiveAgents, ag.ServerName)
+	}
+	st.MinAgents = s.minAgents
+	st.MaxAgents = s.maxAgents
+	st.Schedules = s.schedules
+	st.UpBreaches = s.upBreaches
+	st.DownBreaches = s.downBreaches
+	if !s.lastScaleUp.IsZero() {
+		t := s.lastScaleUp.UTC()
+		st.LastScaleUp = &t
+	}
+	if !s.lastScaleDown.IsZero() {
+		t := s.lastScaleDown.UTC()
+		st.LastScaleDown = &t
+	}
+	st.LeadTimeSeconds = s.leadTime.Seconds()
+	st.InFlightAgents = s.inFlightCount()
+	st.Agents = []AgentStatus{}
+	for _, ag := range s.Config.AvailableAgents {
+		as := s.agent(ag.ServerName)
+		agent := AgentStatus{
+			Name:       ag.ServerName,
+			State:      as.state,
+			Since:      as.since.UTC(),
+			Desired:    as.desired,
+			InUpstream: s.inUpstream(ag.ServerName),
+			Ref:        as.ref,
+			Revision:   as.revision,
+			Failures:   as.failures,
+			LastError:  as.lastErr,
+		}
+		if !as.lastErrAt.IsZero() {
+			t := as.lastErrAt.UTC()
+			agent.LastErrorAt = &t
+		}
+		if !as.drainStart.IsZero() {
+			agent.Drain = &Drain{Since: as.drainStart.UTC(), ActiveConnections: as.connections, InFlightJobs: as.jobs}
+		}
+		if time.Now().Before(as.retryAt) {
+			t := as.retryAt.UTC()
+			agent.RetryAt = &t
+		}
+		st.Agents = append(st.Agents, agent)
+	}
+
+	s.mu.Lock()
+	s.status = *st
+	s.mu.Unlock()
+}
+
+func (s *ScalerEngine) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// ServeStatus writes the latest evaluation as JSON.
+func (s *ScalerEngine) ServeStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Status())
+}
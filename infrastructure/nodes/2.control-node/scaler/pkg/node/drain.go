@@ -0,0 +1,149 @@
+package node
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"scaler/pkg/config"
+)
+
+// UpstreamConnections counts the established TCP connections from this host
+// to the agent's upstream_url. The scaler runs next to nginx, so these are
+// the requests nginx is still proxying to the agent.
+func UpstreamConnections(ctx context.Context, agent config.AgentConfig) (int, error) {
+	u, err := url.Parse(agent.UpstreamURL)
+	if err != nil {
+		return 0, err
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	wantPort, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid upstream port %q", port)
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, file := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		data, err := os.ReadFile(file)
+		if os.IsNotExist(err) && file == "/proc/net/tcp6" {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		lines := strings.Split(string(data), "\n")
+		for _, line := range lines[1:] {
+			fields := strings.Fields(line)
+			// fields[2] is the remote address and fields[3] the socket
+			// state, 01 being ESTABLISHED.
+			if len(fields) < 4 || fields[3] != "01" {
+				continue
+			}
+			ip, port, ok := parseProcAddr(fields[2])
+			if !ok || port != wantPort {
+				continue
+			}
+			for _, addr := range addrs {
+				if addr.IP.Equal(ip) {
+					n++
+					break
+				}
+			}
+		}
+	}
+	return n, nil
+}
+
+// parseProcAddr decodes an address such as "0100007F:1F90" from
+// /proc/net/tcp. The address is printed as 32-bit words in host byte order,
+// which is little-endian on every platform the scaler runs on.
+func parseProcAddr(s string) (net.IP, uint64, bool) {
+	addr, portHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, 0, false
+	}
+	b, err := hex.DecodeString(addr)
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return nil, 0, false
+	}
+	for i := 0; i < len(b); i += 4 {
+		b[i], b[i+1], b[i+2], b[i+3] = b[i+3], b[i+2], b[i+1], b[i]
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return nil, 0, false
+	}
+	return net.IP(b), port, true
+}
+
+// InFlightJobs reads the agent's jobs_metric from the custom metrics in its
+// metrics API response. Agents without a jobs_metric report no jobs.
+func InFlightJobs(ctx context.Context, agent config.AgentConfig) (int, error) {
+	if agent.JobsMetric == "" {
+		return 0, nil
+	}
+	collector, name, _ := strings.Cut(agent.JobsMetric, ".")
+
+	client := http.Client{
+		Timeout:   2 * time.Second,
+		Transport: transport,
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, agent.TelemetryURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("metrics api returned status: %d", resp.StatusCode)
+	}
+
+	var metrics struct {
+		Custom map[string]struct {
+			Metrics map[string]float64 `json:"metrics"`
+			Error   string             `json:"error"`
+		} `json:"custom"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&metrics); err != nil {
+		return 0, err
+	}
+
+	result, ok := metrics.Custom[collector]
+	if !ok {
+		return 0, fmt.Errorf("collector %q is not reported", collector)
+	}
+	if result.Error != "" {
+		return 0, fmt.Errorf("collector %q failed: %s", collector, result.Error)
+	}
+	value, ok := result.Metrics[name]
+	if !ok {
+		return 0, fmt.Errorf("collector %q does not report %q", collector, name)
+	}
+	return int(math.Round(value)), nil
+}
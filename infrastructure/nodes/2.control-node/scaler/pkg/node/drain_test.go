@@ -0,0 +1,33 @@
+package node
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseProcAddr(t *testing.T) {
+	tests := []struct {
+		addr     string
+		wantIP   string
+		wantPort uint64
+		ok       bool
+	}{
+		{"0100007F:1F90", "127.0.0.1", 8080, true},
+		{"0501A8C0:0050", "192.168.1.5", 80, true},
+		{"00000000000000000000000001000000:01BB", "::1", 443, true},
+		{"0000000000000000FFFF00000500000A:1F91", "10.0.0.5", 8081, true},
+		{"0100007F", "", 0, false},
+		{"0100007:1F90", "", 0, false},
+		{"0100007F:XYZ", "", 0, false},
+	}
+	for _, tt := range tests {
+		ip, port, ok := parseProcAddr(tt.addr)
+		if ok != tt.ok {
+			t.Errorf("parseProcAddr(%q) ok = %v, want %v", tt.addr, ok, tt.ok)
+			continue
+		}
+		if ok && (!ip.Equal(net.ParseIP(tt.wantIP)) || port != tt.wantPort) {
+			t.Errorf("parseProcAddr(%q) = %s:%d, want %s:%d", tt.addr, ip, port, tt.wantIP, tt.wantPort)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package node
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"scaler/pkg/config"
+)
+
+// AgentInfo is the subset of the metrics API /info response the scaler uses.
+type AgentInfo struct {
+	Hostname             string    `json:"hostname"`
+	MachineID            string    `json:"machine_id"`
+	Platform             string    `json:"platform"`
+	PlatformVersion      string    `json:"platform_version"`
+	KernelVersion        string    `json:"kernel_version"`
+	CPUCount             int       `json:"cpu_count"`
+	MemoryTotalBytes     uint64    `json:"memory_total_bytes"`
+	BootTime             time.Time `json:"boot_time"`
+	MetricsAPIVersion    string    `json:"metrics_api_version"`
+	DockerVersion        string    `json:"docker_version"`
+	PluggableAPIRevision string    `json:"pluggable_api_revision"`
+}
+
+func GetInfo(ctx context.Context, agent config.AgentConfig) (*AgentInfo, error) {
+	client := http.Client{
+		Timeout:   5 * time.Second,
+		Transport: transport,
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, agent.InfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metrics api returned status: %d", resp.StatusCode)
+	}
+
+	var info AgentInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	if info.MachineID == "" {
+		return nil, fmt.Errorf("metrics api did not report a machine id")
+	}
+	return &info, nil
+}
+
+// VerifyIdentity checks the reported hostname against the agent's configured
+// hostname, which defaults to its server name.
+func VerifyIdentity(agent config.AgentConfig, info *AgentInfo) error {
+	expected := agent.Hostname
+	if expected == "" {
+		expected = agent.ServerName
+	}
+	if info.Hostname != expected {
+		return fmt.Errorf("info_url reports hostname %q, expected %q", info.Hostname, expected)
+	}
+	return nil
+}
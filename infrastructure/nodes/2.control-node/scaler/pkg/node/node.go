@@ -12,8 +12,16 @@ import (
 	"time"
 
 	"scaler/pkg/config"
+	"scaler/pkg/observability"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// transport injects the trace context into outgoing requests so the server
+// manager and metrics API spans join the scaler's trace.
+var transport = otelhttp.NewTransport(http.DefaultTransport)
+
 type MetricsResponse struct {
 	CpuUtilizationPercent    float64 `json:"cpu_utilization_percent"`
 	MemoryUtilizationPercent float64 `json:"memory_utilization_percent"`
@@ -21,23 +29,21 @@ type MetricsResponse struct {
 	Error                    string  `json:"error,omitempty"`
 }
 
-func IsActive(agent config.AgentConfig) bool {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		fmt.Printf("Error getting home directory: %v\n", err)
-		return false
-	}
+func IsActive(ctx context.Context, ssh config.SSHSettings, agent config.AgentConfig) bool {
+	ctx, span := observability.Tracer.Start(ctx, "ssh.probe")
+	span.SetAttributes(attribute.String("agent", agent.ServerName))
+	defer span.End()
 
 	commandArgs := []string{
 		"-p", agent.SSH.Port,
 		"-o", "StrictHostKeyChecking=no",
 		"-o", "UserKnownHostsFile=/dev/null",
-		"-i", fmt.Sprintf("%s/.ssh/id_ed25519", homeDir),
+		"-i", ssh.KeyFile,
 		fmt.Sprintf("%s@%s", agent.SSH.User, agent.SSH.IP),
 		"exit 0",
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, ssh.ConnectTimeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, "ssh", commandArgs...)
@@ -47,13 +53,19 @@ func IsActive(agent config.AgentConfig) bool {
 		if os.Getenv("DEBUG") == "true" {
 			fmt.Printf("Error checking agent status: %v. Output: %s\n", err, string(output))
 		}
+		span.SetAttributes(attribute.Bool("active", false))
 		return false
 	}
 
+	span.SetAttributes(attribute.Bool("active", true))
 	return true
 }
 
-func ManagePower(serverManagerAPI, serverName, action string) error {
+func ManagePower(ctx context.Context, serverManagerAPI, token, serverName, action string) (err error) {
+	ctx, span := observability.Tracer.Start(ctx, "server_manager.power")
+	span.SetAttributes(attribute.String("agent", serverName), attribute.String("action", action))
+	defer func() { observability.End(span, err) }()
+
 	payload := map[string]string{
 		"action": action,
 		"server": serverName,
@@ -63,7 +75,19 @@ func ManagePower(serverManagerAPI, serverName, action string) error {
 		return err
 	}
 
-	resp, err := http.Post(serverManagerAPI+"/api/v1/servers/power", "application/json", bytes.NewBuffer(data))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, serverManagerAPI+"/api/v1/servers/power", bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := http.Client{
+		Transport: transport,
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
@@ -75,11 +99,68 @@ func ManagePower(serverManagerAPI, serverName, action string) error {
 	return nil
 }
 
-func GetMetrics(agent config.AgentConfig) (float64, float64, error) {
+// PowerStates returns the VM state the server manager reports for each of
+// its servers, e.g. "running" or "poweroff". Servers whose state could not be
+// read are reported as "unknown".
+func PowerStates(ctx context.Context, serverManagerAPI, token string) (states map[string]string, err error) {
+	ctx, span := observability.Tracer.Start(ctx, "server_manager.status")
+	defer func() { observability.End(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, serverManagerAPI+"/api/v1/servers/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := http.Client{
+		Timeout:   30 * time.Second,
+		Transport: transport,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server manager returned status: %d", resp.StatusCode)
+	}
+
+	var status struct {
+		Servers []struct {
+			Server string `json:"server"`
+			State  string `json:"state"`
+		} `json:"servers"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, err
+	}
+
+	states = make(map[string]string, len(status.Servers))
+	for _, server := range status.Servers {
+		states[server.Server] = server.State
+	}
+	return states, nil
+}
+
+func GetMetrics(ctx context.Context, agent config.AgentConfig) (cpu float64, mem float64, err error) {
+	ctx, span := observability.Tracer.Start(ctx, "agent.get_metrics")
+	span.SetAttributes(attribute.String("agent", agent.ServerName), attribute.String("source", "pull"))
+	defer func() { observability.End(span, err) }()
+
 	client := http.Client{
-		Timeout: 2 * time.Second,
+		Timeout:   2 * time.Second,
+		Transport: transport,
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, agent.TelemetryURL, nil)
+	if err != nil {
+		return 0, 0, err
 	}
-	resp, err := client.Get(agent.TelemetryURL)
+	req.Header.Set("Accept", "application/json, text/plain;q=0.5")
+
+	resp, err := client.Do(req)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -89,6 +170,10 @@ func GetMetrics(agent config.AgentConfig) (float64, float64, error) {
 		return 0, 0, fmt.Errorf("metrics api returned status: %d", resp.StatusCode)
 	}
 
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		return metricsFromPrometheus(resp.Body)
+	}
+
 	var metrics MetricsResponse
 	if err := json.NewDecoder(resp.Body).Decode(&metrics); err != nil {
 		return 0, 0, err
@@ -101,17 +186,65 @@ func GetMetrics(agent config.AgentConfig) (float64, float64, error) {
 	return metrics.CpuUtilizationPercent, metrics.MemoryUtilizationPercent, nil
 }
 
-func UpdateUpstreamConfig(activeAgents []config.AgentConfig) error {
+// IsReady reports whether the agent's metrics API readiness endpoint answers
+// 200. Agents without a ready_url are always considered ready.
+func IsReady(ctx context.Context, agent config.AgentConfig) bool {
+	if agent.ReadyURL == "" {
+		return true
+	}
+
+	client := http.Client{
+		Timeout:   5 * time.Second,
+		Transport: transport,
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, agent.ReadyURL, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if os.Getenv("DEBUG") == "true" {
+			fmt.Printf("Error checking agent readiness: %v\n", err)
+		}
+		return false
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK
+}
+
+// WaitReady polls IsReady until it succeeds or timeout elapses.
+func WaitReady(ctx context.Context, agent config.AgentConfig, timeout time.Duration) bool {
+	ctx, span := observability.Tracer.Start(ctx, "agent.wait_ready")
+	span.SetAttributes(attribute.String("agent", agent.ServerName))
+	defer span.End()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		if IsReady(ctx, agent) {
+			span.SetAttributes(attribute.Bool("ready", true))
+			return true
+		}
+		if time.Now().After(deadline) {
+			span.SetAttributes(attribute.Bool("ready", false))
+			return false
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func UpdateUpstreamConfig(ctx context.Context, lb config.LoadBalancerConfig, activeAgents []config.AgentConfig) (err error) {
+	ctx, span := observability.Tracer.Start(ctx, "nginx.reload")
+	span.SetAttributes(attribute.Int("upstreams", len(activeAgents)))
+	defer func() { observability.End(span, err) }()
+
 	var upstreamServers []string
 	for _, agent := range activeAgents {
-		url := agent.UpstreamURL
-		url = strings.TrimPrefix(url, "http://")
-		url = strings.TrimPrefix(url, "https://")
-		upstreamServers = append(upstreamServers, fmt.Sprintf("    server %s;", url))
+		upstreamServers = append(upstreamServers, fmt.Sprintf("    server %s;", UpstreamAddr(agent)))
 	}
 
-	upstreamBlock := fmt.Sprintf("upstream backend {\n%s\n}", strings.Join(upstreamServers, "\n"))
-	cmd := exec.Command("sudo", "tee", "/etc/nginx/conf.d/upstream.conf")
+	upstreamBlock := fmt.Sprintf("upstream %s {\n%s\n}", lb.UpstreamName, strings.Join(upstreamServers, "\n"))
+	cmd := exec.CommandContext(ctx, "sudo", "tee", lb.UpstreamFile)
 	cmd.Stdin = strings.NewReader(upstreamBlock)
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("failed to write upstream config: %v", err)
@@ -120,9 +253,35 @@ func UpdateUpstreamConfig(activeAgents []config.AgentConfig) error {
 	fmt.Println("Upstream config:")
 	fmt.Println(upstreamBlock)
 
-	if err := exec.Command("sudo", "systemctl", "reload", "nginx").Run(); err != nil {
+	if err := exec.CommandContext(ctx, lb.ReloadCommand[0], lb.ReloadCommand[1:]...).Run(); err != nil {
 		return fmt.Errorf("failed to reload nginx: %v", err)
 	}
 
 	return nil
 }
+
+// UpstreamAddr is the agent's address as written to the nginx upstream.
+func UpstreamAddr(agent config.AgentConfig) string {
+	url := agent.UpstreamURL
+	url = strings.TrimPrefix(url, "http://")
+	url = strings.TrimPrefix(url, "https://")
+	return url
+}
+
+// ReadUpstreamConfig returns the server addresses in the current upstream
+// file, in the order they are listed.
+func ReadUpstreamConfig(lb config.LoadBalancerConfig) ([]string, error) {
+	data, err := os.ReadFile(lb.UpstreamFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var servers []string
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "server" {
+			servers = append(servers, strings.TrimSuffix(fields[1], ";"))
+		}
+	}
+	return servers, nil
+}
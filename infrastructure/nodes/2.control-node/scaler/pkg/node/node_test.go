@@ -0,0 +1,59 @@
+package node
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"scaler/pkg/config"
+)
+
+func TestUpstreamAddr(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://10.0.0.1:5001", "10.0.0.1:5001"},
+		{"https://agent-1.local:5001", "agent-1.local:5001"},
+		{"10.0.0.1:5001", "10.0.0.1:5001"},
+	}
+	for _, tt := range tests {
+		if got := UpstreamAddr(config.AgentConfig{UpstreamURL: tt.url}); got != tt.want {
+			t.Errorf("UpstreamAddr(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestReadUpstreamConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"written by the scaler", "upstream backend {\n    server 10.0.0.1:5001;\n    server 10.0.0.2:5001;\n}", []string{"10.0.0.1:5001", "10.0.0.2:5001"}},
+		{"server parameters", "upstream backend {\n\tserver 10.0.0.1:5001 weight=2 max_fails=3;\n}\n", []string{"10.0.0.1:5001"}},
+		{"space before semicolon", "upstream backend {\n    server 10.0.0.1:5001 ;\n}\n", []string{"10.0.0.1:5001"}},
+		{"commented out", "upstream backend {\n    # server 10.0.0.1:5001;\n    server 10.0.0.2:5001;\n}\n", []string{"10.0.0.2:5001"}},
+		{"no servers", "upstream backend {\n}\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "upstream.conf")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadUpstreamConfig(config.LoadBalancerConfig{UpstreamFile: path})
+			if err != nil {
+				t.Fatalf("ReadUpstreamConfig() error = %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ReadUpstreamConfig() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ReadUpstreamConfig(config.LoadBalancerConfig{UpstreamFile: filepath.Join(t.TempDir(), "missing.conf")}); err == nil {
+		t.Error("ReadUpstreamConfig() of a missing file returned no error")
+	}
+}
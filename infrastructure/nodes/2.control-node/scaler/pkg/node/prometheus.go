@@ -0,0 +1,60 @@
+package node
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// parsePrometheusText reads unlabelled samples from the Prometheus text
+// exposition format. Comment lines and labelled series are skipped.
+func parsePrometheusText(r io.Reader) (map[string]float64, error) {
+	samples := make(map[string]float64)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.Contains(line, "{") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("malformed sample line: %q", line)
+		}
+
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %v", fields[0], err)
+		}
+		samples[fields[0]] = value
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+func metricsFromPrometheus(r io.Reader) (float64, float64, error) {
+	samples, err := parsePrometheusText(r)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if up, ok := samples["agent_up"]; ok && up == 0 {
+		return 0, 0, fmt.Errorf("metrics api reported agent_up 0")
+	}
+
+	cpu, ok := samples["agent_cpu_utilization_percent"]
+	if !ok {
+		return 0, 0, fmt.Errorf("agent_cpu_utilization_percent missing from metrics")
+	}
+	mem, ok := samples["agent_memory_utilization_percent"]
+	if !ok {
+		return 0, 0, fmt.Errorf("agent_memory_utilization_percent missing from metrics")
+	}
+	return cpu, mem, nil
+}
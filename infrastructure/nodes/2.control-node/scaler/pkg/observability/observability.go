@@ -0,0 +1,138 @@
+package observability
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Setup installs the global tracer and meter providers. OTEL_EXPORTER
+// selects "otlp", "stdout" or "file" (appended to OTEL_EXPORTER_FILE); when
+// it is empty the no-op providers stay in place and the returned shutdown
+// function is nil. The OTLP exporters read the standard OTEL_EXPORTER_OTLP_*
+// variables.
+func Setup(ctx context.Context, service string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	exporter := os.Getenv("OTEL_EXPORTER")
+	if exporter == "" {
+		return nil, nil
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", service)),
+		resource.WithFromEnv(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var spanExporter sdktrace.SpanExporter
+	var metricExporter sdkmetric.Exporter
+	var closer io.Closer
+
+	switch exporter {
+	case "otlp":
+		if spanExporter, err = otlptracehttp.New(ctx); err != nil {
+			return nil, err
+		}
+		if metricExporter, err = otlpmetrichttp.New(ctx); err != nil {
+			return nil, err
+		}
+	case "stdout", "file":
+		var w io.Writer = os.Stdout
+		if exporter == "file" {
+			path := os.Getenv("OTEL_EXPORTER_FILE")
+			if path == "" {
+				return nil, fmt.Errorf("OTEL_EXPORTER=file requires OTEL_EXPORTER_FILE")
+			}
+			f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+			if err != nil {
+				return nil, err
+			}
+			w, closer = f, f
+		}
+		if spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(w)); err != nil {
+			return nil, err
+		}
+		if metricExporter, err = stdoutmetric.New(stdoutmetric.WithWriter(w)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown OTEL_EXPORTER %q, use otlp, stdout or file", exporter)
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	meterProvider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter)),
+		sdkmetric.WithResource(res),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetMeterProvider(meterProvider)
+
+	return func(ctx context.Context) error {
+		err := errors.Join(tracerProvider.Shutdown(ctx), meterProvider.Shutdown(ctx))
+		if closer != nil {
+			err = errors.Join(err, closer.Close())
+		}
+		return err
+	}, nil
+}
+
+// ShutdownOnSignal flushes buffered spans and metrics before the process exits
+// on SIGINT or SIGTERM. It exits with 128 plus the signal number, as the
+// default handler would have. Without an exporter shutdown is nil and the
+// default handling is left in place.
+func ShutdownOnSignal(shutdown func(context.Context) error) {
+	if shutdown == nil {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := shutdown(ctx); err != nil {
+			log.Printf("Error flushing telemetry: %v", err)
+		}
+		cancel()
+		os.Exit(128 + int(sig.(syscall.Signal)))
+	}()
+}
+
+var (
+	Tracer = otel.Tracer("scaler")
+	Meter  = otel.Meter("scaler")
+)
+
+// End records err on span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,108 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"scaler/pkg/config"
+)
+
+// AgentMetrics is one active agent's utilization at the time of a Snapshot.
+type AgentMetrics struct {
+	Agent  string
+	CPU    float64
+	Memory float64
+}
+
+// Sample is the cluster average recorded at one evaluation.
+type Sample struct {
+	Time   time.Time `json:"time"`
+	Agents int       `json:"agents"`
+	CPU    float64   `json:"cpu"`
+	Memory float64   `json:"memory"`
+}
+
+// Snapshot is the cluster state a policy decides on. CPU and Memory are the
+// averages over Metrics, which only lists agents that reported metrics.
+type Snapshot struct {
+	Time    time.Time
+	Active  []string
+	Metrics []AgentMetrics
+	CPU     float64
+	Memory  float64
+	// History holds earlier evaluations, oldest first, including this one.
+	History []Sample
+	// LeadTime is how long it takes from powering on an agent until it
+	// serves traffic.
+	LeadTime time.Duration
+}
+
+// Decision is the number of agents a policy wants. The engine clamps it to
+// min_agents, max_agents and max_step.
+type Decision struct {
+	Policy  string
+	Desired int
+	Reason  string
+	// Forecast is set by the predictive policy.
+	Forecast *Forecast
+}
+
+type Policy interface {
+	Name() string
+	Decide(snap Snapshot) Decision
+}
+
+// Combined asks every policy and follows the one that wants the most agents,
+// so the cluster only shrinks when all of them agree.
+type Combined []Policy
+
+func (c Combined) Name() string {
+	names := make([]string, len(c))
+	for i, p := range c {
+		names[i] = p.Name()
+	}
+	return strings.Join(names, "+")
+}
+
+func (c Combined) Decide(snap Snapshot) Decision {
+	var best Decision
+	var forecast *Forecast
+	for i, p := range c {
+		d := p.Decide(snap)
+		if d.Forecast != nil {
+			forecast = d.Forecast
+		}
+		if i == 0 || d.Desired > best.Desired {
+			best = d
+		}
+	}
+	best.Forecast = forecast
+	return best
+}
+
+// New builds the policies named in cfg.Policies.
+func New(cfg config.ScalingConfig) (Policy, error) {
+	var policies Combined
+	for _, name := range cfg.Policies {
+		switch name {
+		case config.PolicyThreshold:
+			policies = append(policies, Threshold{Up: cfg.ScaleUp, Down: cfg.ScaleDown})
+		case config.PolicyTarget:
+			policies = append(policies, TargetTracking(cfg.Target))
+		case config.PolicyStep:
+			policies = append(policies, Step(cfg.Step))
+		case config.PolicyPredictive:
+			policies = append(policies, Predictive(cfg.Predictive))
+		default:
+			return nil, fmt.Errorf("unknown scaling policy %q", name)
+		}
+	}
+	if len(policies) == 0 {
+		return nil, fmt.Errorf("no scaling policy configured")
+	}
+	if len(policies) == 1 {
+		return policies[0], nil
+	}
+	return policies, nil
+}
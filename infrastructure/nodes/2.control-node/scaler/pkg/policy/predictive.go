@@ -0,0 +1,133 @@
+package policy
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"scaler/pkg/config"
+)
+
+// Holt smoothing factors for the level and the trend.
+const (
+	holtAlpha = 0.5
+	holtBeta  = 0.3
+)
+
+// Forecast is the predictive policy's view of demand one lead time ahead.
+// Demand is the summed utilization of the active agents, in percent of one
+// agent, so 150 means one and a half fully loaded agents.
+type Forecast struct {
+	Model        string        `json:"model"`
+	Metric       string        `json:"metric"`
+	Samples      int           `json:"samples"`
+	Demand       float64       `json:"demand"`
+	Forecast     float64       `json:"forecast"`
+	Horizon      time.Duration `json:"-"`
+	HorizonSecs  float64       `json:"horizon_seconds"`
+	Desired      int           `json:"desired_agents"`
+	ForecastOnly bool          `json:"forecast_only"`
+}
+
+func (f Forecast) String() string {
+	mode := ""
+	if f.ForecastOnly {
+		mode = ", forecast only"
+	}
+	return fmt.Sprintf("%s %s demand %.1f now, %.1f in %s from %d samples, wants %d agents%s",
+		f.Model, f.Metric, f.Demand, f.Forecast, f.Horizon, f.Samples, f.Desired, mode)
+}
+
+// Predictive scales ahead of demand: it fits the history inside the window,
+// forecasts demand one lead time ahead and sizes the cluster so the forecast
+// lands on the target utilization.
+type Predictive config.PredictiveScaling
+
+func (p Predictive) Name() string { return config.PolicyPredictive }
+
+func (p Predictive) Decide(snap Snapshot) Decision {
+	current := len(snap.Active)
+	d := Decision{Policy: p.Name(), Desired: current}
+
+	var times, demand []float64
+	for _, sample := range snap.History {
+		if snap.Time.Sub(sample.Time) > p.Window {
+			continue
+		}
+		value := sample.CPU
+		if p.Metric == "memory" {
+			value = sample.Memory
+		}
+		times = append(times, sample.Time.Sub(snap.Time).Seconds())
+		demand = append(demand, value*float64(sample.Agents))
+	}
+	if len(demand) < 3 {
+		d.Reason = fmt.Sprintf("%d samples in the last %s, need 3 to forecast", len(demand), p.Window)
+		return d
+	}
+
+	horizon := snap.LeadTime.Seconds()
+	var forecast float64
+	if p.Model == config.ModelHolt {
+		forecast = holt(times, demand, horizon)
+	} else {
+		forecast = linear(times, demand, horizon)
+	}
+	forecast = max(forecast, 0)
+
+	f := &Forecast{
+		Model:        p.Model,
+		Metric:       p.Metric,
+		Samples:      len(demand),
+		Demand:       demand[len(demand)-1],
+		Forecast:     forecast,
+		Horizon:      snap.LeadTime.Round(time.Second),
+		HorizonSecs:  snap.LeadTime.Round(time.Second).Seconds(),
+		Desired:      int(math.Ceil(forecast / p.Target)),
+		ForecastOnly: p.ForecastOnly,
+	}
+	d.Forecast = f
+	d.Reason = f.String()
+	if !p.ForecastOnly {
+		d.Desired = f.Desired
+	}
+	return d
+}
+
+// linear fits a least-squares line through the samples, with times in
+// seconds relative to now, and evaluates it horizon seconds ahead.
+func linear(times, values []float64, horizon float64) float64 {
+	n := float64(len(values))
+	var sumT, sumV, sumTT, sumTV float64
+	for i := range values {
+		sumT += times[i]
+		sumV += values[i]
+		sumTT += times[i] * times[i]
+		sumTV += times[i] * values[i]
+	}
+	denominator := n*sumTT - sumT*sumT
+	if denominator == 0 {
+		return sumV / n
+	}
+	slope := (n*sumTV - sumT*sumV) / denominator
+	intercept := (sumV - slope*sumT) / n
+	return intercept + slope*horizon
+}
+
+// holt runs double exponential smoothing over the samples and extrapolates
+// the final level and trend horizon seconds ahead. The trend is kept per
+// second so uneven sample spacing does not distort it.
+func holt(times, values []float64, horizon float64) float64 {
+	level := values[0]
+	trend := 0.0
+	for i := 1; i < len(values); i++ {
+		dt := times[i] - times[i-1]
+		if dt <= 0 {
+			continue
+		}
+		previous := level
+		level = holtAlpha*values[i] + (1-holtAlpha)*(level+trend*dt)
+		trend = holtBeta*(level-previous)/dt + (1-holtBeta)*trend
+	}
+	return level + trend*(horizon-times[len(times)-1])
+}
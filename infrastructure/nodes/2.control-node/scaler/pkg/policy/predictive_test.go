@@ -0,0 +1,96 @@
+package policy
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"scaler/pkg/config"
+)
+
+func TestLinear(t *testing.T) {
+	tests := []struct {
+		name    string
+		times   []float64
+		values  []float64
+		horizon float64
+		want    float64
+	}{
+		{"rising", []float64{-20, -10, 0}, []float64{100, 150, 200}, 60, 500},
+		{"falling", []float64{-20, -10, 0}, []float64{200, 150, 100}, 10, 50},
+		{"flat", []float64{-20, -10, 0}, []float64{80, 80, 80}, 60, 80},
+		{"same time averages", []float64{0, 0, 0}, []float64{60, 90, 120}, 60, 90},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := linear(tt.times, tt.values, tt.horizon); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("linear() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHolt(t *testing.T) {
+	tests := []struct {
+		name    string
+		times   []float64
+		values  []float64
+		horizon float64
+		check   func(float64) bool
+	}{
+		{"flat stays flat", []float64{-20, -10, 0}, []float64{80, 80, 80}, 60, func(v float64) bool { return math.Abs(v-80) < 1e-9 }},
+		{"rising keeps rising", []float64{-30, -20, -10, 0}, []float64{100, 150, 200, 250}, 60, func(v float64) bool { return v > 250 }},
+		{"falling keeps falling", []float64{-30, -20, -10, 0}, []float64{250, 200, 150, 100}, 60, func(v float64) bool { return v < 100 }},
+		{"repeated times are skipped", []float64{-10, -10, 0}, []float64{80, 500, 80}, 0, func(v float64) bool { return math.Abs(v-80) < 1e-9 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := holt(tt.times, tt.values, tt.horizon); !tt.check(got) {
+				t.Errorf("holt() = %v", got)
+			}
+		})
+	}
+}
+
+func TestPredictiveDecide(t *testing.T) {
+	now := time.Now()
+	// rising is cpu demand of 100, 150 and 200 at 20s, 10s and 0s ago, which
+	// the linear model forecasts to 500 one minute ahead.
+	rising := []Sample{
+		{Time: now.Add(-20 * time.Second), Agents: 2, CPU: 50},
+		{Time: now.Add(-10 * time.Second), Agents: 2, CPU: 75},
+		{Time: now, Agents: 2, CPU: 100},
+	}
+	p := Predictive{Metric: "cpu", Target: 100, Model: config.ModelLinear, Window: time.Minute}
+
+	tests := []struct {
+		name         string
+		policy       Predictive
+		history      []Sample
+		want         int
+		wantForecast int
+	}{
+		{"forecasts ahead", p, rising, 5, 5},
+		{"too few samples", p, rising[1:], 2, -1},
+		{"old samples fall out of the window", Predictive{Metric: "cpu", Target: 100, Model: config.ModelLinear, Window: 15 * time.Second}, rising, 2, -1},
+		{"forecast only keeps the count", Predictive{Metric: "cpu", Target: 100, Model: config.ModelLinear, Window: time.Minute, ForecastOnly: true}, rising, 2, 5},
+		{"memory metric", Predictive{Metric: "memory", Target: 100, Model: config.ModelLinear, Window: time.Minute}, rising, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snap := Snapshot{Time: now, Active: []string{"agent-1", "agent-2"}, History: tt.history, LeadTime: time.Minute}
+			d := tt.policy.Decide(snap)
+			if d.Desired != tt.want {
+				t.Errorf("Decide().Desired = %d, want %d (%s)", d.Desired, tt.want, d.Reason)
+			}
+			switch {
+			case tt.wantForecast < 0 && d.Forecast != nil:
+				t.Errorf("Decide().Forecast = %s, want none", d.Forecast)
+			case tt.wantForecast >= 0 && d.Forecast == nil:
+				t.Errorf("Decide().Forecast = nil, want %d agents", tt.wantForecast)
+			case tt.wantForecast >= 0 && d.Forecast.Desired != tt.wantForecast:
+				t.Errorf("Decide().Forecast.Desired = %d, want %d", d.Forecast.Desired, tt.wantForecast)
+			}
+		})
+	}
+}
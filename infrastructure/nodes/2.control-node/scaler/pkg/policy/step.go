@@ -0,0 +1,44 @@
+package policy
+
+import (
+	"fmt"
+
+	"scaler/pkg/config"
+)
+
+// Step scales by the band the average falls in, so a sudden spike adds
+// several agents at once instead of one per evaluation.
+type Step config.StepScaling
+
+func (s Step) Name() string { return config.PolicyStep }
+
+func (s Step) Decide(snap Snapshot) Decision {
+	current := len(snap.Active)
+	value := snap.CPU
+	if s.Metric == "memory" {
+		value = snap.Memory
+	}
+
+	d := Decision{Policy: s.Name(), Desired: current, Reason: fmt.Sprintf("%s %.2f%% in no band", s.Metric, value)}
+	var up, down *config.StepBand
+	for i, band := range s.ScaleUp {
+		if value >= band.Threshold && (up == nil || band.Threshold > up.Threshold) {
+			up = &s.ScaleUp[i]
+		}
+	}
+	for i, band := range s.ScaleDown {
+		if value <= band.Threshold && (down == nil || band.Threshold < down.Threshold) {
+			down = &s.ScaleDown[i]
+		}
+	}
+
+	switch {
+	case up != nil:
+		d.Desired = current + up.Agents
+		d.Reason = fmt.Sprintf("%s %.2f%% at or above %g%%, adding %d", s.Metric, value, up.Threshold, up.Agents)
+	case down != nil:
+		d.Desired = current - down.Agents
+		d.Reason = fmt.Sprintf("%s %.2f%% at or below %g%%, removing %d", s.Metric, value, down.Threshold, down.Agents)
+	}
+	return d
+}
@@ -0,0 +1,39 @@
+package policy
+
+import (
+	"testing"
+
+	"scaler/pkg/config"
+)
+
+func TestStepDecide(t *testing.T) {
+	p := Step{
+		Metric:    "cpu",
+		ScaleUp:   []config.StepBand{{Threshold: 70, Agents: 1}, {Threshold: 90, Agents: 3}},
+		ScaleDown: []config.StepBand{{Threshold: 30, Agents: 1}, {Threshold: 10, Agents: 2}},
+	}
+	tests := []struct {
+		name   string
+		policy Step
+		cpu    float64
+		memory float64
+		want   int
+	}{
+		{"in no band", p, 50, 0, 4},
+		{"at the lower scale_up band", p, 70, 0, 5},
+		{"between scale_up bands", p, 89.9, 0, 5},
+		{"most extreme scale_up band wins", p, 95, 0, 7},
+		{"at the upper scale_down band", p, 30, 0, 3},
+		{"most extreme scale_down band wins", p, 5, 0, 2},
+		{"memory metric", Step{Metric: "memory", ScaleUp: p.ScaleUp}, 10, 75, 5},
+		{"no scale_down bands", Step{Metric: "cpu", ScaleUp: p.ScaleUp}, 5, 0, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snap := Snapshot{Active: []string{"agent-1", "agent-2", "agent-3", "agent-4"}, CPU: tt.cpu, Memory: tt.memory}
+			if got := tt.policy.Decide(snap).Desired; got != tt.want {
+				t.Errorf("Decide() with cpu %.1f, memory %.1f = %d, want %d", tt.cpu, tt.memory, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package policy
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"scaler/pkg/config"
+)
+
+// TargetTracking sizes the cluster so that average utilization stays near
+// the target: ceil(agents * average / target) for each metric with a target,
+// taking the larger count.
+type TargetTracking config.TargetTracking
+
+func (t TargetTracking) Name() string { return config.PolicyTarget }
+
+func (t TargetTracking) Decide(snap Snapshot) Decision {
+	current := len(snap.Active)
+	desired := 0
+	var reasons []string
+	for _, m := range []struct {
+		name          string
+		value, target float64
+	}{
+		{"cpu", snap.CPU, t.CPU},
+		{"memory", snap.Memory, t.Memory},
+	} {
+		if m.target <= 0 {
+			continue
+		}
+		want := current
+		// Within the tolerance band the current count is close enough, which
+		// keeps the count from flapping around the target.
+		if ratio := m.value / m.target; math.Abs(ratio-1) > t.Tolerance {
+			want = int(math.Ceil(float64(current) * ratio))
+		}
+		desired = max(desired, want)
+		reasons = append(reasons, fmt.Sprintf("%s %.2f%% vs target %.0f%%", m.name, m.value, m.target))
+	}
+	return Decision{Policy: t.Name(), Desired: desired, Reason: strings.Join(reasons, ", ")}
+}
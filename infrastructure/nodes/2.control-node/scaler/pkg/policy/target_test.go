@@ -0,0 +1,32 @@
+package policy
+
+import "testing"
+
+func TestTargetTrackingDecide(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy TargetTracking
+		cpu    float64
+		memory float64
+		want   int
+	}{
+		{"on target", TargetTracking{CPU: 60, Tolerance: 0.1}, 60, 0, 2},
+		{"upper band edge", TargetTracking{CPU: 60, Tolerance: 0.1}, 65.9, 0, 2},
+		{"above band", TargetTracking{CPU: 60, Tolerance: 0.1}, 66.1, 0, 3},
+		{"one and a half times target", TargetTracking{CPU: 60, Tolerance: 0.1}, 90, 0, 3},
+		{"just above one and a half", TargetTracking{CPU: 60, Tolerance: 0.1}, 91, 0, 4},
+		{"lower band edge", TargetTracking{CPU: 60, Tolerance: 0.1}, 54.1, 0, 2},
+		{"below band rounds up", TargetTracking{CPU: 60, Tolerance: 0.1}, 53.9, 0, 2},
+		{"half target", TargetTracking{CPU: 60, Tolerance: 0.1}, 29, 0, 1},
+		{"larger metric wins", TargetTracking{CPU: 60, Memory: 50, Tolerance: 0.1}, 60, 100, 4},
+		{"no target", TargetTracking{Tolerance: 0.1}, 90, 90, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snap := Snapshot{Active: []string{"agent-1", "agent-2"}, CPU: tt.cpu, Memory: tt.memory}
+			if got := tt.policy.Decide(snap).Desired; got != tt.want {
+				t.Errorf("Decide() with cpu %.1f, memory %.1f = %d, want %d", tt.cpu, tt.memory, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package policy
+
+import (
+	"fmt"
+
+	"scaler/pkg/config"
+)
+
+// Threshold adds one agent when average CPU or memory is above Up and removes
+// one when both are below Down.
+type Threshold struct {
+	Up   config.Thresholds
+	Down config.Thresholds
+}
+
+func (t Threshold) Name() string { return config.PolicyThreshold }
+
+func (t Threshold) Decide(snap Snapshot) Decision {
+	current := len(snap.Active)
+	d := Decision{Policy: t.Name(), Desired: current, Reason: "within thresholds"}
+	switch {
+	case snap.CPU > t.Up.CPU || snap.Memory > t.Up.Memory:
+		d.Desired = current + 1
+		d.Reason = fmt.Sprintf("cpu %.2f%%, memory %.2f%% above scale_up", snap.CPU, snap.Memory)
+	case snap.CPU < t.Down.CPU && snap.Memory < t.Down.Memory:
+		d.Desired = current - 1
+		d.Reason = fmt.Sprintf("cpu %.2f%%, memory %.2f%% below scale_down", snap.CPU, snap.Memory)
+	}
+	return d
+}
@@ -0,0 +1,96 @@
+package policy
+
+import (
+	"testing"
+
+	"scaler/pkg/config"
+)
+
+func TestThresholdDecide(t *testing.T) {
+	p := Threshold{Up: config.Thresholds{CPU: 80, Memory: 80}, Down: config.Thresholds{CPU: 20, Memory: 20}}
+	tests := []struct {
+		name        string
+		cpu, memory float64
+		want        int
+	}{
+		{"within thresholds", 50, 50, 2},
+		{"at scale_up", 80, 80, 2},
+		{"cpu above scale_up", 80.1, 10, 3},
+		{"memory above scale_up", 10, 90, 3},
+		{"both below scale_down", 19.9, 19.9, 1},
+		{"only cpu below scale_down", 10, 30, 2},
+		{"at scale_down", 20, 20, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snap := Snapshot{Active: []string{"agent-1", "agent-2"}, CPU: tt.cpu, Memory: tt.memory}
+			if got := p.Decide(snap).Desired; got != tt.want {
+				t.Errorf("Decide() with cpu %.1f, memory %.1f = %d, want %d", tt.cpu, tt.memory, got, tt.want)
+			}
+		})
+	}
+}
+
+type fixed struct {
+	name     string
+	desired  int
+	forecast *Forecast
+}
+
+func (f fixed) Name() string { return f.name }
+
+func (f fixed) Decide(Snapshot) Decision {
+	return Decision{Policy: f.name, Desired: f.desired, Forecast: f.forecast}
+}
+
+func TestCombinedDecide(t *testing.T) {
+	forecast := &Forecast{Desired: 1}
+	tests := []struct {
+		name         string
+		policies     Combined
+		wantPolicy   string
+		want         int
+		wantForecast *Forecast
+	}{
+		{"most agents wins", Combined{fixed{"a", 2, nil}, fixed{"b", 4, nil}, fixed{"c", 3, nil}}, "b", 4, nil},
+		{"first wins a tie", Combined{fixed{"a", 3, nil}, fixed{"b", 3, nil}}, "a", 3, nil},
+		{"shrinks only when all agree", Combined{fixed{"a", 1, nil}, fixed{"b", 2, nil}}, "b", 2, nil},
+		{"keeps the forecast of another policy", Combined{fixed{"a", 5, nil}, fixed{"predictive", 1, forecast}}, "a", 5, forecast},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := tt.policies.Decide(Snapshot{})
+			if d.Policy != tt.wantPolicy || d.Desired != tt.want {
+				t.Errorf("Decide() = %s wanting %d, want %s wanting %d", d.Policy, d.Desired, tt.wantPolicy, tt.want)
+			}
+			if d.Forecast != tt.wantForecast {
+				t.Errorf("Decide() forecast = %v, want %v", d.Forecast, tt.wantForecast)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies []string
+		want     string
+		wantErr  bool
+	}{
+		{"single policy", []string{config.PolicyThreshold}, "threshold", false},
+		{"combined", []string{config.PolicyThreshold, config.PolicyTarget}, "threshold+target", false},
+		{"unknown", []string{"random"}, "", true},
+		{"none", nil, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(config.ScalingConfig{Policies: tt.policies})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, want error: %v", err, tt.wantErr)
+			}
+			if err == nil && p.Name() != tt.want {
+				t.Errorf("New().Name() = %q, want %q", p.Name(), tt.want)
+			}
+		})
+	}
+}
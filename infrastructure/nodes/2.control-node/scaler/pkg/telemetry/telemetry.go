@@ -0,0 +1,194 @@
+package telemetry
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const signatureHeader = "X-Telemetry-Signature"
+
+// maxClockSkew bounds how far sent_at may be from the scaler's clock. It keeps
+// captured requests from being replayed later.
+const maxClockSkew = 60 * time.Second
+
+type Metrics struct {
+	CpuUtilizationPercent    float64 `json:"cpu_utilization_percent"`
+	MemoryUtilizationPercent float64 `json:"memory_utilization_percent"`
+	Status                   string  `json:"status"`
+	Error                    string  `json:"error,omitempty"`
+}
+
+type Push struct {
+	Agent   string    `json:"agent"`
+	SentAt  time.Time `json:"sent_at"`
+	Metrics Metrics   `json:"metrics"`
+}
+
+type Snapshot struct {
+	Agent      string    `json:"agent"`
+	SentAt     time.Time `json:"sent_at"`
+	ReceivedAt time.Time `json:"received_at"`
+	Metrics    Metrics   `json:"metrics"`
+}
+
+type SnapshotStatus struct {
+	Snapshot
+	AgeSeconds float64 `json:"age_seconds"`
+	Stale      bool    `json:"stale"`
+}
+
+// Store keeps the latest pushed snapshot per agent. A snapshot older than
+// staleAfter is not used for scaling decisions.
+type Store struct {
+	mu         sync.RWMutex
+	latest     map[string]Snapshot
+	secret     []byte
+	staleAfter time.Duration
+	known      map[string]bool
+}
+
+func NewStore(secret string, staleAfter time.Duration, agents []string) *Store {
+	known := make(map[string]bool, len(agents))
+	for _, a := range agents {
+		known[a] = true
+	}
+	return &Store{
+		latest:     make(map[string]Snapshot),
+		secret:     []byte(secret),
+		staleAfter: staleAfter,
+		known:      known,
+	}
+}
+
+// Reconfigure applies a reloaded configuration. Snapshots from agents that are
+// no longer configured are dropped.
+func (s *Store) Reconfigure(secret string, staleAfter time.Duration, agents []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.secret = []byte(secret)
+	s.staleAfter = staleAfter
+	s.known = make(map[string]bool, len(agents))
+	for _, a := range agents {
+		s.known[a] = true
+	}
+	for agent := range s.latest {
+		if !s.known[agent] {
+			delete(s.latest, agent)
+		}
+	}
+}
+
+// Fresh returns the agent's latest snapshot if it is not stale.
+func (s *Store) Fresh(agent string) (Snapshot, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snap, ok := s.latest[agent]
+	if !ok || time.Since(snap.ReceivedAt) > s.staleAfter {
+		return Snapshot{}, false
+	}
+	return snap, true
+}
+
+func (s *Store) Status() []SnapshotStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	statuses := []SnapshotStatus{}
+	for _, snap := range s.latest {
+		age := time.Since(snap.ReceivedAt)
+		statuses = append(statuses, SnapshotStatus{
+			Snapshot:   snap,
+			AgeSeconds: age.Seconds(),
+			Stale:      age > s.staleAfter,
+		})
+	}
+	return statuses
+}
+
+func (s *Store) verify(body []byte, header string) bool {
+	provided, ok := strings.CutPrefix(header, "sha256=")
+	if !ok {
+		return false
+	}
+	expected, err := hex.DecodeString(provided)
+	if err != nil {
+		return false
+	}
+	s.mu.RLock()
+	mac := hmac.New(sha256.New, s.secret)
+	s.mu.RUnlock()
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// ServeHTTP accepts signed pushes on POST and lists the stored snapshots on GET.
+func (s *Store) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.Status())
+	case http.MethodPost:
+		s.ingest(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Store) ingest(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Invalid body", http.StatusBadRequest)
+		return
+	}
+
+	if !s.verify(body, r.Header.Get(signatureHeader)) {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	var push Push
+	if err := json.Unmarshal(body, &push); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.RLock()
+	known := s.known[push.Agent]
+	s.mu.RUnlock()
+	if !known {
+		log.Printf("Rejected telemetry from unknown agent %q", push.Agent)
+		http.Error(w, "Unknown agent", http.StatusForbidden)
+		return
+	}
+
+	now := time.Now()
+	if skew := now.Sub(push.SentAt); skew > maxClockSkew || skew < -maxClockSkew {
+		http.Error(w, "sent_at outside allowed clock skew", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if prev, ok := s.latest[push.Agent]; ok && !push.SentAt.After(prev.SentAt) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	s.latest[push.Agent] = Snapshot{
+		Agent:      push.Agent,
+		SentAt:     push.SentAt,
+		ReceivedAt: now,
+		Metrics:    push.Metrics,
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
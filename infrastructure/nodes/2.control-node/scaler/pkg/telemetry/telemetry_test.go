@@ -0,0 +1,115 @@
+package telemetry
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// The metrics API's push tests sign the same body with the same secret and
+// expect this signature, so a change to either side's signing breaks one of
+// the two modules' tests.
+const (
+	testSecret    = "shared-secret"
+	testBody      = `{"agent":"frodo","sent_at":"2025-01-01T10:00:00Z","metrics":{"cpu_utilization_percent":15.2,"memory_utilization_percent":50,"status":"active"}}`
+	testSignature = "sha256=9a1b5e8571a53a28e4f48eac0afe91e28081a36dd1eb24cbb81cadb454a3e5e7"
+)
+
+func TestVerifyMetricsAPISignature(t *testing.T) {
+	s := NewStore(testSecret, time.Minute, []string{"frodo"})
+	if !s.verify([]byte(testBody), testSignature) {
+		t.Error("verify() rejected the signature produced by the metrics API")
+	}
+}
+
+func signedPush(t *testing.T, secret string, push Push) ([]byte, string) {
+	t.Helper()
+	body, err := json.Marshal(push)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return body, "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestIngest(t *testing.T) {
+	now := time.Now().UTC()
+	fresh := Push{Agent: "frodo", SentAt: now, Metrics: Metrics{CpuUtilizationPercent: 42, Status: "active"}}
+
+	validBody, validSig := signedPush(t, testSecret, fresh)
+	tamperedBody := bytes.Replace(validBody, []byte(`"cpu_utilization_percent":42`), []byte(`"cpu_utilization_percent":99`), 1)
+	wrongKeyBody, wrongKeySig := signedPush(t, "other-secret", fresh)
+	staleBody, staleSig := signedPush(t, testSecret, Push{Agent: "frodo", SentAt: now.Add(-2 * maxClockSkew)})
+	futureBody, futureSig := signedPush(t, testSecret, Push{Agent: "frodo", SentAt: now.Add(2 * maxClockSkew)})
+	unknownBody, unknownSig := signedPush(t, testSecret, Push{Agent: "sauron", SentAt: now})
+
+	tests := []struct {
+		name      string
+		body      []byte
+		signature string
+		wantCode  int
+		wantCPU   float64
+		wantStore bool
+	}{
+		{"signed", validBody, validSig, http.StatusNoContent, 42, true},
+		{"tampered body", tamperedBody, validSig, http.StatusUnauthorized, 0, false},
+		{"wrong secret", wrongKeyBody, wrongKeySig, http.StatusUnauthorized, 0, false},
+		{"unsigned", validBody, "", http.StatusUnauthorized, 0, false},
+		{"missing sha256 prefix", validBody, validSig[len("sha256="):], http.StatusUnauthorized, 0, false},
+		{"signature not hex", validBody, "sha256=not-hex", http.StatusUnauthorized, 0, false},
+		{"stale", staleBody, staleSig, http.StatusBadRequest, 0, false},
+		{"from the future", futureBody, futureSig, http.StatusBadRequest, 0, false},
+		{"unknown agent", unknownBody, unknownSig, http.StatusForbidden, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewStore(testSecret, time.Minute, []string{"frodo"})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/telemetry", bytes.NewReader(tt.body))
+			if tt.signature != "" {
+				req.Header.Set(signatureHeader, tt.signature)
+			}
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			snap, ok := s.Fresh("frodo")
+			if ok != tt.wantStore || snap.Metrics.CpuUtilizationPercent != tt.wantCPU {
+				t.Errorf("Fresh() = %+v, %v, want %v CPU stored %v", snap, ok, tt.wantCPU, tt.wantStore)
+			}
+		})
+	}
+}
+
+func TestIngestKeepsNewestSnapshot(t *testing.T) {
+	s := NewStore(testSecret, time.Minute, []string{"frodo"})
+	now := time.Now().UTC()
+
+	for _, push := range []Push{
+		{Agent: "frodo", SentAt: now, Metrics: Metrics{CpuUtilizationPercent: 20}},
+		{Agent: "frodo", SentAt: now.Add(-time.Second), Metrics: Metrics{CpuUtilizationPercent: 90}},
+		{Agent: "frodo", SentAt: now, Metrics: Metrics{CpuUtilizationPercent: 90}},
+	} {
+		body, sig := signedPush(t, testSecret, push)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/telemetry", bytes.NewReader(body))
+		req.Header.Set(signatureHeader, sig)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusNoContent)
+		}
+	}
+
+	if snap, _ := s.Fresh("frodo"); snap.Metrics.CpuUtilizationPercent != 20 {
+		t.Errorf("CPU = %v after older and replayed pushes, want 20", snap.Metrics.CpuUtilizationPercent)
+	}
+}
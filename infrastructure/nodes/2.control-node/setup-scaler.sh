@@ -36,6 +36,7 @@ User=$USER_NAME
 WorkingDirectory=$APP_DIR
 EnvironmentFile=$APP_DIR/.env
 ExecStart=$APP_DIR/$BINARY_NAME
+ExecReload=/bin/kill -HUP \$MAINPID
 Restart=on-failure
 
 [Install]
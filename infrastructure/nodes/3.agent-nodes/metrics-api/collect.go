@@ -0,0 +1,252 @@
+package main
+
+import (
+	"log"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	psnet "github.com/shirou/gopsutil/v3/net"
+)
+
+const sampleWindow = 100 * time.Millisecond
+
+type LoadMetrics struct {
+	Load1  float64 `json:"load1"`
+	Load5  float64 `json:"load5"`
+	Load15 float64 `json:"load15"`
+}
+
+type ProcessMetrics struct {
+	Total   int `json:"total"`
+	Running int `json:"running"`
+	Blocked int `json:"blocked"`
+}
+
+type DiskMetrics struct {
+	Path               string  `json:"path"`
+	TotalBytes         uint64  `json:"total_bytes"`
+	UsedBytes          uint64  `json:"used_bytes"`
+	UtilizationPercent float64 `json:"utilization_percent"`
+	ReadBytesPerSec    float64 `json:"read_bytes_per_sec"`
+	WriteBytesPerSec   float64 `json:"write_bytes_per_sec"`
+	ReadOpsPerSec      float64 `json:"read_ops_per_sec"`
+	WriteOpsPerSec     float64 `json:"write_ops_per_sec"`
+}
+
+type NetworkMetrics struct {
+	ReceiveBytesPerSec    float64 `json:"receive_bytes_per_sec"`
+	TransmitBytesPerSec   float64 `json:"transmit_bytes_per_sec"`
+	TCPConnectionsCurrent int64   `json:"tcp_connections_established"`
+}
+
+// NodeSnapshot holds one reading of the node's resource usage. CPU and memory
+// are always present; the remaining fields are nil when the platform cannot
+// report them.
+type NodeSnapshot struct {
+	CPUPercent     float64
+	CPUCorePercent []float64
+	Memory         *mem.VirtualMemoryStat
+	Load           *LoadMetrics
+	Procs          *ProcessMetrics
+	Disk           *DiskMetrics
+	Network        *NetworkMetrics
+	Interfaces     []psnet.IOCountersStat
+	Pressure       *PressureMetrics
+}
+
+func diskPath() string {
+	if path := os.Getenv("DISK_PATH"); path != "" {
+		return path
+	}
+	return "/"
+}
+
+type counterReading struct {
+	at   time.Time
+	cpu  []cpu.TimesStat
+	disk disk.IOCountersStat
+	net  psnet.IOCountersStat
+}
+
+// Collector produces NodeSnapshots. CPU usage and disk/network rates are
+// computed against the counters read by the previous call, so a snapshot
+// covers the whole time since the last one. The first call primes the
+// counters and measures over sampleWindow instead.
+type Collector struct {
+	prev *counterReading
+}
+
+func readCounters() (*counterReading, error) {
+	times, err := cpu.Times(true)
+	if err != nil {
+		return nil, err
+	}
+	reading := &counterReading{at: time.Now(), cpu: times}
+
+	if counters, err := disk.IOCounters(); err == nil {
+		reading.disk = sumDiskCounters(counters)
+	}
+	if counters, err := psnet.IOCounters(true); err == nil {
+		reading.net = sumNetCounters(counters)
+	}
+	return reading, nil
+}
+
+func (c *Collector) Collect() (*NodeSnapshot, error) {
+	if c.prev == nil {
+		prev, err := readCounters()
+		if err != nil {
+			return nil, err
+		}
+		c.prev = prev
+		time.Sleep(sampleWindow)
+	}
+
+	cur, err := readCounters()
+	if err != nil {
+		return nil, err
+	}
+	prev := c.prev
+	c.prev = cur
+	elapsed := cur.at.Sub(prev.at).Seconds()
+
+	corePercent := make([]float64, len(cur.cpu))
+	for i := range cur.cpu {
+		if i < len(prev.cpu) {
+			corePercent[i] = busyPercent(prev.cpu[i], cur.cpu[i])
+		}
+	}
+
+	vMem, err := mem.VirtualMemory()
+	if err != nil {
+		return nil, err
+	}
+
+	interfaces, netErr := psnet.IOCounters(true)
+
+	snapshot := &NodeSnapshot{
+		CPUPercent:     average(corePercent),
+		CPUCorePercent: corePercent,
+		Memory:         vMem,
+		Interfaces:     interfaces,
+	}
+
+	if avg, err := load.Avg(); err != nil {
+		log.Printf("Error reading load average: %v", err)
+	} else {
+		snapshot.Load = &LoadMetrics{Load1: avg.Load1, Load5: avg.Load5, Load15: avg.Load15}
+	}
+
+	if misc, err := load.Misc(); err != nil {
+		log.Printf("Error reading process counts: %v", err)
+	} else {
+		snapshot.Procs = &ProcessMetrics{Total: misc.ProcsTotal, Running: misc.ProcsRunning, Blocked: misc.ProcsBlocked}
+	}
+
+	if usage, err := disk.Usage(diskPath()); err != nil {
+		log.Printf("Error reading disk usage for %s: %v", diskPath(), err)
+	} else {
+		snapshot.Disk = &DiskMetrics{
+			Path:               usage.Path,
+			TotalBytes:         usage.Total,
+			UsedBytes:          usage.Used,
+			UtilizationPercent: usage.UsedPercent,
+			ReadBytesPerSec:    rate(prev.disk.ReadBytes, cur.disk.ReadBytes, elapsed),
+			WriteBytesPerSec:   rate(prev.disk.WriteBytes, cur.disk.WriteBytes, elapsed),
+			ReadOpsPerSec:      rate(prev.disk.ReadCount, cur.disk.ReadCount, elapsed),
+			WriteOpsPerSec:     rate(prev.disk.WriteCount, cur.disk.WriteCount, elapsed),
+		}
+	}
+
+	if netErr != nil {
+		log.Printf("Error reading network counters: %v", netErr)
+	} else {
+		snapshot.Network = &NetworkMetrics{
+			ReceiveBytesPerSec:  rate(prev.net.BytesRecv, cur.net.BytesRecv, elapsed),
+			TransmitBytesPerSec: rate(prev.net.BytesSent, cur.net.BytesSent, elapsed),
+		}
+		if counters, err := psnet.ProtoCounters([]string{"tcp"}); err == nil && len(counters) > 0 {
+			snapshot.Network.TCPConnectionsCurrent = counters[0].Stats["CurrEstab"]
+		}
+	}
+
+	if snapshot.Pressure, err = readPressure(); err != nil {
+		log.Printf("Error reading pressure stall information: %v", err)
+	}
+
+	return snapshot, nil
+}
+
+// busyPercent mirrors gopsutil's cpu.Percent calculation for two readings of
+// the same core. Guest time is already part of user time on Linux.
+func busyPercent(t1, t2 cpu.TimesStat) float64 {
+	busy := func(t cpu.TimesStat) (float64, float64) {
+		total := t.Total() - t.Guest - t.GuestNice
+		return total, total - t.Idle - t.Iowait
+	}
+
+	t1All, t1Busy := busy(t1)
+	t2All, t2Busy := busy(t2)
+	if t2Busy <= t1Busy {
+		return 0
+	}
+	if t2All <= t1All {
+		return 100
+	}
+	return math.Min(100, math.Max(0, (t2Busy-t1Busy)/(t2All-t1All)*100))
+}
+
+// sumDiskCounters adds up whole block devices only, so partitions are not
+// counted twice. Loop and RAM devices are ignored.
+func sumDiskCounters(counters map[string]disk.IOCountersStat) disk.IOCountersStat {
+	var total disk.IOCountersStat
+	for name, c := range counters {
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+			continue
+		}
+		if _, err := os.Stat("/sys/block/" + name); err != nil {
+			continue
+		}
+		total.ReadBytes += c.ReadBytes
+		total.WriteBytes += c.WriteBytes
+		total.ReadCount += c.ReadCount
+		total.WriteCount += c.WriteCount
+	}
+	return total
+}
+
+func sumNetCounters(counters []psnet.IOCountersStat) psnet.IOCountersStat {
+	var total psnet.IOCountersStat
+	for _, c := range counters {
+		if c.Name == "lo" {
+			continue
+		}
+		total.BytesRecv += c.BytesRecv
+		total.BytesSent += c.BytesSent
+	}
+	return total
+}
+
+func rate(before, after uint64, seconds float64) float64 {
+	if after < before || seconds <= 0 {
+		return 0
+	}
+	return float64(after-before) / seconds
+}
+
+func average(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+type ContainerMetrics struct {
+	ID                 string  `json:"id"`
+	Name               string  `json:"name"`
+	Image              string  `json:"image"`
+	State              string  `json:"state"`
+	Status             string  `json:"status"`
+	Health             string  `json:"health,omitempty"`
+	RestartCount       int     `json:"restart_count"`
+	CpuPercent         float64 `json:"cpu_percent"`
+	MemoryUsageBytes   uint64  `json:"memory_usage_bytes"`
+	MemoryLimitBytes   uint64  `json:"memory_limit_bytes"`
+	MemoryUsagePercent float64 `json:"memory_usage_percent"`
+	Error              string  `json:"error,omitempty"`
+}
+
+type ServiceMetrics struct {
+	Service          string             `json:"service"`
+	Running          int                `json:"running"`
+	Total            int                `json:"total"`
+	CpuPercent       float64            `json:"cpu_percent"`
+	MemoryUsageBytes uint64             `json:"memory_usage_bytes"`
+	RestartCount     int                `json:"restart_count"`
+	Containers       []ContainerMetrics `json:"containers"`
+}
+
+type ProjectMetrics struct {
+	Project  string           `json:"project"`
+	Services []ServiceMetrics `json:"services"`
+}
+
+type ContainersResponse struct {
+	Projects []ProjectMetrics `json:"projects"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// collectContainers reads every container from the Docker engine and groups
+// them by compose project and service. Containers that do not belong to a
+// compose project are grouped under an empty project name with the container
+// name as service. Stats are read concurrently because each call blocks for
+// about a second on the engine side.
+func collectContainers(ctx context.Context, docker *DockerClient, project string) ([]ProjectMetrics, error) {
+	containers, err := docker.ListContainers(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]ContainerMetrics, len(containers))
+	var wg sync.WaitGroup
+	for i, c := range containers {
+		if project != "" && c.Labels[composeProjectLabel] != project {
+			continue
+		}
+
+		metrics[i] = ContainerMetrics{
+			ID:     c.ID[:12],
+			Name:   c.Name(),
+			Image:  c.Image,
+			State:  c.State,
+			Status: c.Status,
+		}
+
+		wg.Add(1)
+		go func(m *ContainerMetrics, id string, running bool) {
+			defer wg.Done()
+
+			inspect, err := docker.Inspect(ctx, id)
+			if err != nil {
+				m.Error = err.Error()
+				return
+			}
+			m.RestartCount = inspect.RestartCount
+			if inspect.State.Health != nil {
+				m.Health = inspect.State.Health.Status
+			}
+
+			if !running {
+				return
+			}
+			stats, err := docker.Stats(ctx, id)
+			if err != nil {
+				m.Error = err.Error()
+				return
+			}
+			m.CpuPercent = stats.CPUPercent()
+			m.MemoryUsageBytes = stats.MemoryUsage()
+			m.MemoryLimitBytes = stats.MemoryStats.Limit
+			if m.MemoryLimitBytes > 0 {
+				m.MemoryUsagePercent = float64(m.MemoryUsageBytes) / float64(m.MemoryLimitBytes) * 100
+			}
+		}(&metrics[i], c.ID, c.State == "running")
+	}
+	wg.Wait()
+
+	grouped := map[string]map[string]*ServiceMetrics{}
+	for i, c := range containers {
+		if project != "" && c.Labels[composeProjectLabel] != project {
+			continue
+		}
+
+		projectName := c.Labels[composeProjectLabel]
+		serviceName := c.Labels[composeServiceLabel]
+		if serviceName == "" {
+			serviceName = c.Name()
+		}
+
+		if grouped[projectName] == nil {
+			grouped[projectName] = map[string]*ServiceMetrics{}
+		}
+		svc := grouped[projectName][serviceName]
+		if svc == nil {
+			svc = &ServiceMetrics{Service: serviceName}
+			grouped[projectName][serviceName] = svc
+		}
+
+		m := metrics[i]
+		svc.Total++
+		if m.State == "running" {
+			svc.Running++
+		}
+		svc.CpuPercent += m.CpuPercent
+		svc.MemoryUsageBytes += m.MemoryUsageBytes
+		svc.RestartCount += m.RestartCount
+		svc.Containers = append(svc.Containers, m)
+	}
+
+	projects := []ProjectMetrics{}
+	for name, services := range grouped {
+		p := ProjectMetrics{Project: name}
+		for _, svc := range services {
+			p.Services = append(p.Services, *svc)
+		}
+		sort.Slice(p.Services, func(a, b int) bool { return p.Services[a].Service < p.Services[b].Service })
+		projects = append(projects, p)
+	}
+	sort.Slice(projects, func(a, b int) bool { return projects[a].Project < projects[b].Project })
+
+	return projects, nil
+}
+
+func containersHandler(docker *DockerClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		projects, err := collectContainers(ctx, docker, r.URL.Query().Get("project"))
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(ContainersResponse{Error: err.Error()})
+			return
+		}
+
+		json.NewEncoder(w).Encode(ContainersResponse{Projects: projects})
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CollectorConfig is one file in COLLECTORS_DIR. Type "exec" runs Command and
+// parses its stdout; type "file" reads Path. Format is "json", "prometheus" or
+// "value" (a single number); when empty it is detected from the output.
+type CollectorConfig struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Command  []string `json:"command,omitempty"`
+	Path     string   `json:"path,omitempty"`
+	Format   string   `json:"format,omitempty"`
+	Timeout  string   `json:"timeout,omitempty"`
+	Interval string   `json:"interval,omitempty"`
+
+	timeout  time.Duration
+	interval time.Duration
+}
+
+type CustomResult struct {
+	Metrics     map[string]float64 `json:"metrics"`
+	CollectedAt time.Time          `json:"collected_at"`
+	DurationMs  int64              `json:"duration_ms"`
+	Error       string             `json:"error,omitempty"`
+}
+
+// CustomCollectors runs every configured collector on its own interval and
+// keeps the latest result of each, so /metrics never waits for a plugin.
+type CustomCollectors struct {
+	collectors []CollectorConfig
+
+	mu      sync.RWMutex
+	results map[string]CustomResult
+}
+
+// LoadCustomCollectors reads every *.json file in dir. An empty dir disables
+// custom collectors.
+func LoadCustomCollectors(dir string) (*CustomCollectors, error) {
+	c := &CustomCollectors{results: map[string]CustomResult{}}
+	if dir == "" {
+		return c, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	seen := map[string]string{}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var cfg CollectorConfig
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		if err := cfg.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		if other, ok := seen[cfg.Name]; ok {
+			return nil, fmt.Errorf("%s: collector name %q already used by %s", file, cfg.Name, other)
+		}
+		seen[cfg.Name] = file
+
+		c.collectors = append(c.collectors, cfg)
+	}
+	return c, nil
+}
+
+func (cfg *CollectorConfig) validate() error {
+	if cfg.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+
+	switch cfg.Type {
+	case "exec":
+		if len(cfg.Command) == 0 {
+			return fmt.Errorf("exec collector requires command")
+		}
+	case "file":
+		if cfg.Path == "" {
+			return fmt.Errorf("file collector requires path")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", cfg.Type)
+	}
+
+	switch cfg.Format {
+	case "", "json", "prometheus", "value":
+	default:
+		return fmt.Errorf("unknown format %q", cfg.Format)
+	}
+
+	var err error
+	cfg.timeout = 2 * time.Second
+	if cfg.Timeout != "" {
+		if cfg.timeout, err = time.ParseDuration(cfg.Timeout); err != nil || cfg.timeout <= 0 {
+			return fmt.Errorf("invalid timeout %q", cfg.Timeout)
+		}
+	}
+	cfg.interval = 10 * time.Second
+	if cfg.Interval != "" {
+		if cfg.interval, err = time.ParseDuration(cfg.Interval); err != nil || cfg.interval <= 0 {
+			return fmt.Errorf("invalid interval %q", cfg.Interval)
+		}
+	}
+	return nil
+}
+
+func (c *CustomCollectors) Len() int {
+	return len(c.collectors)
+}
+
+func (c *CustomCollectors) Run(stop <-chan struct{}) {
+	for _, cfg := range c.collectors {
+		go c.runCollector(cfg, stop)
+	}
+}
+
+func (c *CustomCollectors) runCollector(cfg CollectorConfig, stop <-chan struct{}) {
+	c.collect(cfg)
+
+	ticker := time.NewTicker(cfg.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.collect(cfg)
+		}
+	}
+}
+
+func (c *CustomCollectors) collect(cfg CollectorConfig) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+
+	start := time.Now()
+	metrics, err := cfg.read(ctx)
+	result := CustomResult{
+		Metrics:     metrics,
+		CollectedAt: time.Now().UTC(),
+		DurationMs:  time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		log.Printf("Custom collector %s failed: %v", cfg.Name, err)
+		result.Error = err.Error()
+		result.Metrics = map[string]float64{}
+	}
+
+	c.mu.Lock()
+	c.results[cfg.Name] = result
+	c.mu.Unlock()
+}
+
+// Results returns the latest result of every collector that has run.
+func (c *CustomCollectors) Results() map[string]CustomResult {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.results) == 0 {
+		return nil
+	}
+	results := make(map[string]CustomResult, len(c.results))
+	for name, r := range c.results {
+		results[name] = r
+	}
+	return results
+}
+
+func (cfg CollectorConfig) read(ctx context.Context) (map[string]float64, error) {
+	var output []byte
+	var err error
+
+	switch cfg.Type {
+	case "exec":
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, cfg.Command[0], cfg.Command[1:]...)
+		cmd.Stderr = &stderr
+		output, err = cmd.Output()
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("timed out after %s", cfg.timeout)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+	case "file":
+		output, err = os.ReadFile(cfg.Path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return parseCustomOutput(output, cfg.Format)
+}
+
+func parseCustomOutput(output []byte, format string) (map[string]float64, error) {
+	trimmed := bytes.TrimSpace(output)
+	if format == "" {
+		switch {
+		case bytes.HasPrefix(trimmed, []byte("{")):
+			format = "json"
+		case isNumber(string(trimmed)):
+			format = "value"
+		default:
+			format = "prometheus"
+		}
+	}
+
+	switch format {
+	case "value":
+		v, err := parseFinite(string(trimmed))
+		if err != nil {
+			return nil, fmt.Errorf("expected a single number: %v", err)
+		}
+		return map[string]float64{"value": v}, nil
+	case "json":
+		var doc map[string]interface{}
+		if err := json.Unmarshal(trimmed, &doc); err != nil {
+			return nil, fmt.Errorf("invalid JSON output: %v", err)
+		}
+		metrics := map[string]float64{}
+		flattenJSON("", doc, metrics)
+		return metrics, nil
+	default:
+		return parsePrometheusSamples(trimmed)
+	}
+}
+
+// flattenJSON keeps numeric and boolean leaves, joining nested keys with ".".
+func flattenJSON(prefix string, value interface{}, out map[string]float64) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			key := k
+			if prefix != "" {
+				key = prefix + "." + k
+			}
+			flattenJSON(key, child, out)
+		}
+	case float64:
+		out[prefix] = v
+	case bool:
+		if v {
+			out[prefix] = 1
+		} else {
+			out[prefix] = 0
+		}
+	}
+}
+
+// parsePrometheusSamples reads the text exposition format. Labelled series are
+// keyed by their full series name, e.g. `queue_depth{queue="fib"}`. NaN and
+// infinite samples are valid in the format but cannot be encoded as JSON, so
+// they are skipped.
+func parsePrometheusSamples(output []byte) (map[string]float64, error) {
+	metrics := map[string]float64{}
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		series, rest := line, ""
+		if i := strings.LastIndex(line, "}"); i >= 0 {
+			series, rest = line[:i+1], strings.TrimSpace(line[i+1:])
+		} else if fields := strings.Fields(line); len(fields) >= 2 {
+			series, rest = fields[0], fields[1]
+		}
+
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("malformed sample line: %q", line)
+		}
+		v, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value in line %q", line)
+		}
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		metrics[series] = v
+	}
+	return metrics, nil
+}
+
+// parseFinite parses s as a float64, rejecting NaN and infinities.
+func parseFinite(s string) (float64, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, fmt.Errorf("%q is not a finite number", s)
+	}
+	return v, nil
+}
+
+func isNumber(s string) bool {
+	_, err := parseFinite(s)
+	return err == nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParseCustomOutput(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		format  string
+		want    map[string]float64
+		wantErr bool
+	}{
+		{"value", "42\n", "value", map[string]float64{"value": 42}, false},
+		{"value detected", " 3.5e2 ", "", map[string]float64{"value": 350}, false},
+		{"value not a number", "busy", "value", nil, true},
+		{"value NaN", "NaN", "value", nil, true},
+		{"value infinite", "+Inf", "value", nil, true},
+		{"value NaN detected", "NaN", "", nil, true},
+
+		{"json", `{"queue": {"depth": 7, "paused": false}, "workers": 3, "name": "fib"}`, "json", map[string]float64{"queue.depth": 7, "queue.paused": 0, "workers": 3}, false},
+		{"json detected", `{"up": true}`, "", map[string]float64{"up": 1}, false},
+		{"json invalid", `{"up": `, "json", nil, true},
+
+		{
+			"prometheus",
+			"# HELP queue_depth Jobs waiting.\n# TYPE queue_depth gauge\nqueue_depth{queue=\"fib\"} 7\nqueue_depth{queue=\"io\"} 2 1700000000000\nsessions 12\n",
+			"prometheus",
+			map[string]float64{`queue_depth{queue="fib"}`: 7, `queue_depth{queue="io"}`: 2, "sessions": 12},
+			false,
+		},
+		{"prometheus detected", "sessions 12\n", "", map[string]float64{"sessions": 12}, false},
+		{"prometheus label with spaces", `requests{path="/a b"} 5`, "prometheus", map[string]float64{`requests{path="/a b"}`: 5}, false},
+		{
+			"prometheus NaN and infinite samples skipped",
+			"latency_seconds{quantile=\"0.5\"} NaN\nlimit +Inf\nfloor -Inf\nsessions 12\n",
+			"prometheus",
+			map[string]float64{"sessions": 12},
+			false,
+		},
+		{"prometheus missing value", "sessions\n", "prometheus", nil, true},
+		{"prometheus labels without value", `queue_depth{queue="fib"}`, "prometheus", nil, true},
+		{"prometheus invalid value", "sessions many\n", "prometheus", nil, true},
+		{"prometheus empty", "# nothing to report\n", "prometheus", map[string]float64{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCustomOutput([]byte(tt.output), tt.format)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseCustomOutput(%q, %q) = %v, want an error", tt.output, tt.format, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseCustomOutput(%q, %q) error = %v", tt.output, tt.format, err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("parseCustomOutput(%q, %q) = %v, want %v", tt.output, tt.format, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsNumber(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"1", true},
+		{"-0.25", true},
+		{"1e3", true},
+		{"", false},
+		{"one", false},
+		{"NaN", false},
+		{"Inf", false},
+		{"-Inf", false},
+		{"1e400", false},
+	}
+	for _, tt := range tests {
+		if got := isNumber(tt.s); got != tt.want {
+			t.Errorf("isNumber(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxReleaseHistory = 50
+
+// maxLogLine bounds one line of command output streamed as a "log" event.
+// Docker build output can put a whole progress bar on a single line.
+const maxLogLine = 1 << 20
+
+// DeploySpec describes one deploy of the pluggable API. Exactly one of Repo
+// and ArtifactURL must be set; ArtifactURL points at a .tar.gz of the source.
+type DeploySpec struct {
+	Repo        string            `json:"repo,omitempty"`
+	Ref         string            `json:"ref,omitempty"`
+	ArtifactURL string            `json:"artifact_url,omitempty"`
+	ComposePath string            `json:"compose_path"`
+	Project     string            `json:"project,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+}
+
+// DeployEvent is one line of the NDJSON progress stream. The final event has
+// Step "done" and carries the release record.
+type DeployEvent struct {
+	Time    time.Time `json:"time"`
+	Step    string    `json:"step"`
+	Message string    `json:"message,omitempty"`
+	Release *Release  `json:"release,omitempty"`
+}
+
+type Release struct {
+	ID          string    `json:"id"`
+	Status      string    `json:"status"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	Repo        string    `json:"repo,omitempty"`
+	Ref         string    `json:"ref,omitempty"`
+	ArtifactURL string    `json:"artifact_url,omitempty"`
+	Revision    string    `json:"revision,omitempty"`
+	ComposePath string    `json:"compose_path"`
+	Project     string    `json:"project"`
+	RolledBack  string    `json:"rolled_back_to,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Deployer runs deploys on this agent. Every release is unpacked into its own
+// directory under root/releases, root/current links to the live release and
+// root/releases.json keeps the history.
+type Deployer struct {
+	root    string
+	token   string
+	keep    int
+	timeout time.Duration
+
+	mu sync.Mutex
+}
+
+func deployRoot() string {
+	if root := os.Getenv("DEPLOY_ROOT"); root != "" {
+		return root
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, "pluggable-api-releases")
+}
+
+// NewDeployerFromEnv returns nil when the deploy API is not configured
+// (DEPLOY_TOKEN unset), so deploys cannot be triggered unauthenticated.
+func NewDeployerFromEnv() *Deployer {
+	token := os.Getenv("DEPLOY_TOKEN")
+	if token == "" {
+		return nil
+	}
+
+	root := deployRoot()
+	if root == "" {
+		log.Fatal("DEPLOY_TOKEN is set but DEPLOY_ROOT is empty and the home directory is unknown")
+	}
+
+	keep := 5
+	if v := os.Getenv("DEPLOY_KEEP_RELEASES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("Invalid DEPLOY_KEEP_RELEASES %q", v)
+		}
+		keep = n
+	}
+
+	// Keep this at least as long as deploy.timeout on the scaler, or a slow
+	// deploy is cut off here while the scaler still waits for it.
+	timeout := durationEnv("DEPLOY_TIMEOUT", 60*time.Minute)
+
+	if err := os.MkdirAll(filepath.Join(root, "releases"), 0o755); err != nil {
+		log.Fatalf("Error creating deploy root %s: %v", root, err)
+	}
+	return &Deployer{root: root, token: token, keep: keep, timeout: timeout}
+}
+
+// currentDir returns the live release directory, or "" before the first deploy.
+func (d *Deployer) currentDir() string {
+	target, err := filepath.EvalSymlinks(filepath.Join(d.root, "current"))
+	if err != nil {
+		return ""
+	}
+	return target
+}
+
+func (spec *DeploySpec) validate() error {
+	if (spec.Repo == "") == (spec.ArtifactURL == "") {
+		return fmt.Errorf("exactly one of repo and artifact_url is required")
+	}
+	if spec.ComposePath == "" {
+		return fmt.Errorf("compose_path is required")
+	}
+	spec.ComposePath = strings.TrimPrefix(filepath.Clean(spec.ComposePath), "/")
+	if !filepath.IsLocal(spec.ComposePath) {
+		return fmt.Errorf("compose_path must stay inside the release: %q", spec.ComposePath)
+	}
+	if spec.Ref == "" && spec.Repo != "" {
+		spec.Ref = "HEAD"
+	}
+	if spec.Project == "" {
+		spec.Project = "compose"
+	}
+	for key := range spec.Env {
+		if key == "" || strings.ContainsAny(key, "=\r\n ") {
+			return fmt.Errorf("invalid env name %q", key)
+		}
+	}
+	return nil
+}
+
+// progress writes DeployEvents to the response, flushing each one so the
+// caller sees the deploy as it happens.
+type progress struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	enc     *json.Encoder
+}
+
+func (p *progress) emit(step, format string, args ...interface{}) {
+	p.send(DeployEvent{Time: time.Now().UTC(), Step: step, Message: fmt.Sprintf(format, args...)})
+}
+
+func (p *progress) send(event DeployEvent) {
+	p.enc.Encode(event)
+	if p.flusher != nil {
+		p.flusher.Flush()
+	}
+}
+
+// run executes a command, streaming every output line as a "log" event.
+func (p *progress) run(ctx context.Context, dir string, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(out)
+	scanner.Buffer(make([]byte, 64*1024), maxLogLine)
+	for scanner.Scan() {
+		p.emit("log", "%s", scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		p.emit("log", "Not streaming the rest of the output: %v", err)
+		// Keep reading so the command does not block on a full pipe.
+		io.Copy(io.Discard, out)
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%s %s: %v", name, strings.Join(args, " "), err)
+	}
+	return nil
+}
+
+func (d *Deployer) deployHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		deployError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var spec DeploySpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		deployError(w, http.StatusBadRequest, "Invalid JSON: "+err.Error())
+		return
+	}
+	if err := spec.validate(); err != nil {
+		deployError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if !d.mu.TryLock() {
+		deployError(w, http.StatusConflict, "A deploy is already running")
+		return
+	}
+	defer d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	p := &progress{w: w, flusher: flusher, enc: json.NewEncoder(w)}
+
+	// The deploy keeps running if the caller disconnects; a half-finished
+	// compose up is worse than a deploy nobody watches.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), d.timeout)
+	defer cancel()
+
+	release := d.deploy(ctx, spec, p)
+	p.send(DeployEvent{Time: time.Now().UTC(), Step: "done", Message: release.Status, Release: release})
+}
+
+func (d *Deployer) deploy(ctx context.Context, spec DeploySpec, p *progress) *Release {
+	release := &Release{
+		ID:          time.Now().UTC().Format("20060102T150405Z"),
+		Status:      "failed",
+		StartedAt:   time.Now().UTC(),
+		Repo:        spec.Repo,
+		Ref:         spec.Ref,
+		ArtifactURL: spec.ArtifactURL,
+		ComposePath: spec.ComposePath,
+		Project:     spec.Project,
+	}
+	previous := d.currentDir()
+	dir := filepath.Join(d.root, "releases", release.ID)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			break
+		}
+		release.ID = fmt.Sprintf("%s-%d", release.ID[:16], i)
+		dir = filepath.Join(d.root, "releases", release.ID)
+	}
+
+	err := d.install(ctx, spec, dir, release, p)
+	if err != nil {
+		release.Error = err.Error()
+		p.emit("error", "%v", err)
+		if previous != "" && previous != dir {
+			p.emit("rollback", "Restarting previous release %s", filepath.Base(previous))
+			if err := p.run(ctx, filepath.Join(previous, d.composePath(filepath.Base(previous), spec.ComposePath)), "docker", "compose", "-p", spec.Project, "up", "-d", "--remove-orphans"); err != nil {
+				p.emit("error", "Rollback failed: %v", err)
+			} else {
+				release.RolledBack = filepath.Base(previous)
+			}
+		}
+		os.RemoveAll(dir)
+	} else {
+		release.Status = "succeeded"
+	}
+	release.FinishedAt = time.Now().UTC()
+
+	if err := d.record(release); err != nil {
+		log.Printf("Error recording release %s: %v", release.ID, err)
+	}
+	d.prune(p)
+	log.Printf("Deploy %s %s", release.ID, release.Status)
+	return release
+}
+
+func (d *Deployer) install(ctx context.Context, spec DeploySpec, dir string, release *Release, p *progress) error {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return fmt.Errorf("docker is not installed on this agent")
+	}
+
+	if spec.Repo != "" {
+		p.emit("fetch", "Fetching %s at %s", spec.Repo, spec.Ref)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		steps := [][]string{
+			{"init", "-q"},
+			{"remote", "add", "origin", spec.Repo},
+			{"fetch", "--depth=1", "origin", spec.Ref},
+			{"checkout", "-q", "--detach", "FETCH_HEAD"},
+		}
+		for _, args := range steps {
+			if err := p.run(ctx, dir, "git", args...); err != nil {
+				return err
+			}
+		}
+		rev, err := gitRevision(dir)
+		if err != nil {
+			return err
+		}
+		release.Revision = rev
+		p.emit("fetch", "Checked out %s", rev)
+	} else {
+		p.emit("fetch", "Downloading %s", spec.ArtifactURL)
+		if err := downloadArtifact(ctx, spec.ArtifactURL, dir); err != nil {
+			return err
+		}
+	}
+
+	composeDir := filepath.Join(dir, spec.ComposePath)
+	if _, err := os.Stat(filepath.Join(composeDir, "docker-compose.yml")); err != nil {
+		return fmt.Errorf("docker-compose.yml not found in %s", spec.ComposePath)
+	}
+
+	p.emit("env", "Writing %d variable(s) to %s/.env", len(spec.Env), spec.ComposePath)
+	if err := writeEnvFile(filepath.Join(composeDir, ".env"), spec.Env); err != nil {
+		return err
+	}
+
+	p.emit("compose", "Starting project %s", spec.Project)
+	if err := p.run(ctx, composeDir, "docker", "compose", "-p", spec.Project, "up", "-d", "--build", "--remove-orphans"); err != nil {
+		return err
+	}
+
+	p.emit("activate", "Activating release %s", release.ID)
+	link := filepath.Join(d.root, "current")
+	tmp := link + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(filepath.Join("releases", release.ID), tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, link)
+}
+
+// writeEnvFile writes values as plain data: line breaks are stripped so a
+// value cannot inject further variables.
+func writeEnvFile(path string, env map[string]string) error {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		value := strings.NewReplacer("\r", "", "\n", "").Replace(env[key])
+		fmt.Fprintf(&b, "%s=%s\n", key, value)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o600)
+}
+
+func downloadArtifact(ctx context.Context, url, dir string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("artifact download returned status %d", resp.StatusCode)
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !filepath.IsLocal(hdr.Name) {
+			return fmt.Errorf("artifact entry escapes the release: %q", hdr.Name)
+		}
+		target := filepath.Join(dir, hdr.Name)
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode)&0o755|0o600)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// composePath returns the compose path recorded for release id, or fallback
+// when the release is not in the history.
+func (d *Deployer) composePath(id, fallback string) string {
+	releases, err := d.Releases()
+	if err != nil {
+		return fallback
+	}
+	for _, r := range releases {
+		if r.ID == id {
+			return r.ComposePath
+		}
+	}
+	return fallback
+}
+
+func (d *Deployer) historyPath() string {
+	return filepath.Join(d.root, "releases.json")
+}
+
+// Releases returns the release history, newest first.
+func (d *Deployer) Releases() ([]Release, error) {
+	data, err := os.ReadFile(d.historyPath())
+	if os.IsNotExist(err) {
+		return []Release{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var releases []Release
+	if err := json.Unmarshal(data, &releases); err != nil {
+		return nil, err
+	}
+	return releases, nil
+}
+
+func (d *Deployer) record(release *Release) error {
+	releases, err := d.Releases()
+	if err != nil {
+		return err
+	}
+	releases = append([]Release{*release}, releases...)
+	if len(releases) > maxReleaseHistory {
+		releases = releases[:maxReleaseHistory]
+	}
+
+	data, err := json.MarshalIndent(releases, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := d.historyPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, d.historyPath())
+}
+
+// prune keeps the newest release directories, never removing the live one.
+func (d *Deployer) prune(p *progress) {
+	entries, err := os.ReadDir(filepath.Join(d.root, "releases"))
+	if err != nil {
+		return
+	}
+	current := d.currentDir()
+
+	var ids []string
+	for _, e := range entries {
+		if e.IsDir() {
+			ids = append(ids, e.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+
+	for i, id := range ids {
+		if i < d.keep || filepath.Join(d.root, "releases", id) == current {
+			continue
+		}
+		p.emit("prune", "Removing old release %s", id)
+		os.RemoveAll(filepath.Join(d.root, "releases", id))
+	}
+}
+
+func (d *Deployer) releasesHandler(w http.ResponseWriter, r *http.Request) {
+	releases, err := d.Releases()
+	if err != nil {
+		deployError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	current := ""
+	if dir := d.currentDir(); dir != "" {
+		current = filepath.Base(dir)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"current":  current,
+		"releases": releases,
+	})
+}
+
+func deployError(w http.ResponseWriter, code int, message string) {
+	jsonReply(w, code, map[string]string{"error": message})
+}
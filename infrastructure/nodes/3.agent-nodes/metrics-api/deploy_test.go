@@ -0,0 +1,201 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDeploySpecValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		spec        DeploySpec
+		wantErr     bool
+		wantRef     string
+		wantPath    string
+		wantProject string
+	}{
+		{"repo", DeploySpec{Repo: "https://example.com/api.git", ComposePath: "compose"}, false, "HEAD", "compose", "compose"},
+		{"repo with ref and project", DeploySpec{Repo: "https://example.com/api.git", Ref: "v1.2", ComposePath: "compose", Project: "api"}, false, "v1.2", "compose", "api"},
+		{"artifact", DeploySpec{ArtifactURL: "https://example.com/api.tar.gz", ComposePath: "./compose/"}, false, "", "compose", "compose"},
+		{"absolute compose path", DeploySpec{Repo: "https://example.com/api.git", ComposePath: "/srv/compose"}, false, "HEAD", "srv/compose", "compose"},
+		{"repo and artifact", DeploySpec{Repo: "https://example.com/api.git", ArtifactURL: "https://example.com/api.tar.gz", ComposePath: "compose"}, true, "", "", ""},
+		{"neither repo nor artifact", DeploySpec{ComposePath: "compose"}, true, "", "", ""},
+		{"missing compose path", DeploySpec{Repo: "https://example.com/api.git"}, true, "", "", ""},
+		{"compose path escapes", DeploySpec{Repo: "https://example.com/api.git", ComposePath: "compose/../../etc"}, true, "", "", ""},
+		{"empty env name", DeploySpec{Repo: "https://example.com/api.git", ComposePath: "compose", Env: map[string]string{"": "x"}}, true, "", "", ""},
+		{"env name with =", DeploySpec{Repo: "https://example.com/api.git", ComposePath: "compose", Env: map[string]string{"A=B": "x"}}, true, "", "", ""},
+		{"env name with newline", DeploySpec{Repo: "https://example.com/api.git", ComposePath: "compose", Env: map[string]string{"A\nB": "x"}}, true, "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := tt.spec
+			err := spec.validate()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("validate() of %+v returned no error", tt.spec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validate() error = %v", err)
+			}
+			if spec.Ref != tt.wantRef || spec.ComposePath != tt.wantPath || spec.Project != tt.wantProject {
+				t.Errorf("validated spec has ref %q, compose path %q, project %q, want %q, %q, %q",
+					spec.Ref, spec.ComposePath, spec.Project, tt.wantRef, tt.wantPath, tt.wantProject)
+			}
+		})
+	}
+}
+
+func TestWriteEnvFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".env")
+	err := writeEnvFile(path, map[string]string{
+		"WORKERS":  "4",
+		"API_KEY":  "secret\nINJECTED=1",
+		"GREETING": "hello world\r",
+	})
+	if err != nil {
+		t.Fatalf("writeEnvFile() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "API_KEY=secretINJECTED=1\nGREETING=hello world\nWORKERS=4\n"
+	if string(data) != want {
+		t.Errorf(".env = %q, want %q", data, want)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf(".env mode = %o, want 600", mode)
+	}
+}
+
+// tarball returns a .tar.gz holding a regular file for each name.
+func tarball(t *testing.T, names ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, name := range names {
+		content := []byte("content of " + name)
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDownloadArtifact(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		wantErr bool
+	}{
+		{"release files", []string{"compose/docker-compose.yml", "api/main.go"}, false},
+		{"parent directory", []string{"compose/docker-compose.yml", "../escaped"}, true},
+		{"nested parent directory", []string{"compose/../../escaped"}, true},
+		{"absolute path", []string{"/tmp/escaped"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := tarball(t, tt.entries...)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(archive)
+			}))
+			defer srv.Close()
+
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "release")
+			err := downloadArtifact(context.Background(), srv.URL, dir)
+			if _, statErr := os.Stat(filepath.Join(parent, "escaped")); statErr == nil {
+				t.Error("an artifact entry was written outside the release directory")
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Error("downloadArtifact() returned no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("downloadArtifact() error = %v", err)
+			}
+			for _, name := range tt.entries {
+				data, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil || string(data) != "content of "+name {
+					t.Errorf("%s = %q, %v, want its content", name, data, err)
+				}
+			}
+		})
+	}
+
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+	if err := downloadArtifact(context.Background(), srv.URL, t.TempDir()); err == nil {
+		t.Error("downloadArtifact() of a missing artifact returned no error")
+	}
+}
+
+func TestProgressRunLongLines(t *testing.T) {
+	tests := []struct {
+		name       string
+		length     int
+		streamed   bool
+		wantEvents int
+	}{
+		{"longer than the scanner default", 100 * 1024, true, 2},
+		{"longer than maxLogLine", 2 * maxLogLine, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			p := &progress{w: rec, enc: json.NewEncoder(rec)}
+
+			script := "head -c " + strconv.Itoa(tt.length) + " /dev/zero | tr '\\0' a; echo; echo done"
+			if err := p.run(context.Background(), t.TempDir(), "sh", "-c", script); err != nil {
+				t.Fatalf("run() error = %v", err)
+			}
+
+			var events []DeployEvent
+			for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n") {
+				var event DeployEvent
+				if err := json.Unmarshal([]byte(line), &event); err != nil {
+					t.Fatalf("invalid event %q: %v", line, err)
+				}
+				events = append(events, event)
+			}
+			if len(events) != tt.wantEvents {
+				t.Fatalf("%d events, want %d", len(events), tt.wantEvents)
+			}
+			if got := len(events[0].Message); tt.streamed && got != tt.length {
+				t.Errorf("first line streamed with %d bytes, want %d", got, tt.length)
+			}
+			if !tt.streamed && !strings.HasPrefix(events[0].Message, "Not streaming") {
+				t.Errorf("event = %q, want a note that the output was cut", events[0].Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+const (
+	composeProjectLabel = "com.docker.compose.project"
+	composeServiceLabel = "com.docker.compose.service"
+)
+
+func dockerSocket() string {
+	if socket := os.Getenv("DOCKER_SOCKET"); socket != "" {
+		return socket
+	}
+	return "/var/run/docker.sock"
+}
+
+// DockerClient talks to the Docker Engine API over its unix socket. Only the
+// handful of read-only endpoints the metrics API needs are implemented.
+type DockerClient struct {
+	socket string
+	http   *http.Client
+}
+
+func NewDockerClient(socket string) *DockerClient {
+	return &DockerClient{
+		socket: socket,
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+type dockerContainer struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	State  string            `json:"State"`
+	Status string            `json:"Status"`
+	Labels map[string]string `json:"Labels"`
+}
+
+func (c dockerContainer) Name() string {
+	if len(c.Names) == 0 {
+		return c.ID[:12]
+	}
+	name := c.Names[0]
+	if len(name) > 0 && name[0] == '/' {
+		name = name[1:]
+	}
+	return name
+}
+
+type dockerInspect struct {
+	RestartCount int `json:"RestartCount"`
+	State        struct {
+		Status   string `json:"Status"`
+		ExitCode int    `json:"ExitCode"`
+		Health   *struct {
+			Status string `json:"Status"`
+		} `json:"Health"`
+	} `json:"State"`
+}
+
+type dockerCPUStats struct {
+	CPUUsage struct {
+		TotalUsage uint64 `json:"total_usage"`
+	} `json:"cpu_usage"`
+	SystemUsage uint64 `json:"system_cpu_usage"`
+	OnlineCPUs  int    `json:"online_cpus"`
+}
+
+type dockerStats struct {
+	CPUStats    dockerCPUStats `json:"cpu_stats"`
+	PreCPUStats dockerCPUStats `json:"precpu_stats"`
+	MemoryStats struct {
+		Usage uint64            `json:"usage"`
+		Limit uint64            `json:"limit"`
+		Stats map[string]uint64 `json:"stats"`
+	} `json:"memory_stats"`
+}
+
+// CPUPercent follows the calculation used by `docker stats`.
+func (s dockerStats) CPUPercent() float64 {
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(s.CPUStats.SystemUsage) - float64(s.PreCPUStats.SystemUsage)
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+	cpus := s.CPUStats.OnlineCPUs
+	if cpus == 0 {
+		cpus = 1
+	}
+	return cpuDelta / systemDelta * float64(cpus) * 100
+}
+
+// MemoryUsage excludes the page cache, as `docker stats` does
+// ("inactive_file" on cgroup v2, "cache" on cgroup v1).
+func (s dockerStats) MemoryUsage() uint64 {
+	usage := s.MemoryStats.Usage
+	cache, ok := s.MemoryStats.Stats["inactive_file"]
+	if !ok {
+		cache = s.MemoryStats.Stats["cache"]
+	}
+	if cache < usage {
+		usage -= cache
+	}
+	return usage
+}
+
+func (d *DockerClient) get(ctx context.Context, path string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://docker"+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("docker socket %s: %w", d.socket, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("docker api %s returned status %d: %s", path, resp.StatusCode, body)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (d *DockerClient) ListContainers(ctx context.Context, all bool) ([]dockerContainer, error) {
+	var containers []dockerContainer
+	path := "/containers/json"
+	if all {
+		path += "?all=1"
+	}
+	if err := d.get(ctx, path, &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+func (d *DockerClient) Inspect(ctx context.Context, id string) (*dockerInspect, error) {
+	var inspect dockerInspect
+	if err := d.get(ctx, "/containers/"+url.PathEscape(id)+"/json", &inspect); err != nil {
+		return nil, err
+	}
+	return &inspect, nil
+}
+
+// Stats returns a single stats reading. The engine waits for a second reading
+// before answering so precpu_stats is populated.
+func (d *DockerClient) Stats(ctx context.Context, id string) (*dockerStats, error) {
+	var stats dockerStats
+	if err := d.get(ctx, "/containers/"+url.PathEscape(id)+"/stats?stream=false", &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
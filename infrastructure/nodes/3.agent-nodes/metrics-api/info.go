@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// version is set at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+type InfoResponse struct {
+	Hostname          string    `json:"hostname"`
+	MachineID         string    `json:"machine_id"`
+	OS                string    `json:"os"`
+	Platform          string    `json:"platform"`
+	PlatformVersion   string    `json:"platform_version"`
+	KernelVersion     string    `json:"kernel_version"`
+	Arch              string    `json:"arch"`
+	CPUCount          int       `json:"cpu_count"`
+	MemoryTotalBytes  uint64    `json:"memory_total_bytes"`
+	BootTime          time.Time `json:"boot_time"`
+	MetricsAPIVersion string    `json:"metrics_api_version"`
+	DockerVersion     string    `json:"docker_version,omitempty"`
+	PluggableAPIRev   string    `json:"pluggable_api_revision,omitempty"`
+	PluggableAPIPath  string    `json:"pluggable_api_path,omitempty"`
+	Errors            []string  `json:"errors,omitempty"`
+}
+
+// pluggableAPIDir is the live release of the deploy API if there is one, and
+// otherwise where the scaler's SSH deploy script clones the pluggable API.
+func pluggableAPIDir() string {
+	if dir := os.Getenv("PLUGGABLE_API_DIR"); dir != "" {
+		return dir
+	}
+	if root := deployRoot(); root != "" {
+		if dir, err := filepath.EvalSymlinks(filepath.Join(root, "current")); err == nil {
+			return dir
+		}
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, "pluggable-api")
+}
+
+// gitRevision resolves HEAD of the repository in dir without shelling out to
+// git, following a symbolic ref through loose refs and packed-refs.
+func gitRevision(dir string) (string, error) {
+	gitDir := filepath.Join(dir, ".git")
+	head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return "", err
+	}
+
+	ref := strings.TrimSpace(string(head))
+	if !strings.HasPrefix(ref, "ref: ") {
+		return ref, nil
+	}
+	ref = strings.TrimPrefix(ref, "ref: ")
+
+	if rev, err := os.ReadFile(filepath.Join(gitDir, filepath.FromSlash(ref))); err == nil {
+		return strings.TrimSpace(string(rev)), nil
+	}
+
+	packed, err := os.Open(filepath.Join(gitDir, "packed-refs"))
+	if err != nil {
+		return "", err
+	}
+	defer packed.Close()
+
+	scanner := bufio.NewScanner(packed)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == ref {
+			return fields[0], nil
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+func (d *DockerClient) Version(ctx context.Context) (string, error) {
+	var v struct {
+		Version string `json:"Version"`
+	}
+	if err := d.get(ctx, "/version", &v); err != nil {
+		return "", err
+	}
+	return v.Version, nil
+}
+
+func collectInfo(ctx context.Context, docker *DockerClient) InfoResponse {
+	info := InfoResponse{
+		OS:                runtime.GOOS,
+		Arch:              runtime.GOARCH,
+		CPUCount:          runtime.NumCPU(),
+		MetricsAPIVersion: version,
+	}
+
+	if h, err := host.InfoWithContext(ctx); err != nil {
+		info.Errors = append(info.Errors, "host: "+err.Error())
+	} else {
+		info.Hostname = h.Hostname
+		info.MachineID = h.HostID
+		info.Platform = h.Platform
+		info.PlatformVersion = h.PlatformVersion
+		info.KernelVersion = h.KernelVersion
+		info.Arch = h.KernelArch
+		info.BootTime = time.Unix(int64(h.BootTime), 0).UTC()
+	}
+
+	if vMem, err := mem.VirtualMemoryWithContext(ctx); err != nil {
+		info.Errors = append(info.Errors, "memory: "+err.Error())
+	} else {
+		info.MemoryTotalBytes = vMem.Total
+	}
+
+	if v, err := docker.Version(ctx); err != nil {
+		info.Errors = append(info.Errors, "docker: "+err.Error())
+	} else {
+		info.DockerVersion = v
+	}
+
+	if dir := pluggableAPIDir(); dir != "" {
+		info.PluggableAPIPath = dir
+		if rev, err := gitRevision(dir); err != nil {
+			info.Errors = append(info.Errors, "pluggable api: "+err.Error())
+		} else {
+			info.PluggableAPIRev = rev
+		}
+	}
+
+	return info
+}
+
+func infoHandler(docker *DockerClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		info := collectInfo(ctx, docker)
+		for _, e := range info.Errors {
+			log.Printf("Error collecting node info: %s", e)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+const (
+	maxLoadDuration = 30 * time.Minute
+	dutyCyclePeriod = 100 * time.Millisecond
+)
+
+type LoadRequest struct {
+	CPUPercent float64 `json:"cpu_percent"`
+	MemoryMB   int     `json:"memory_mb"`
+	Duration   string  `json:"duration"`
+}
+
+type LoadStatus struct {
+	Active     bool       `json:"active"`
+	CPUPercent float64    `json:"cpu_percent,omitempty"`
+	MemoryMB   int        `json:"memory_mb,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	EndsAt     *time.Time `json:"ends_at,omitempty"`
+}
+
+// LoadGenerator burns CPU and holds memory on request so scaling scenarios
+// can be driven without putting traffic through the application.
+type LoadGenerator struct {
+	token string
+
+	mu     sync.Mutex
+	status LoadStatus
+	stop   chan struct{}
+}
+
+// NewLoadGeneratorFromEnv returns nil when LOAD_TOKEN is unset, which keeps
+// the debug endpoint off unless explicitly enabled.
+func NewLoadGeneratorFromEnv() *LoadGenerator {
+	token := os.Getenv("LOAD_TOKEN")
+	if token == "" {
+		return nil
+	}
+	return &LoadGenerator{token: token}
+}
+
+// Start replaces any running load with the requested one.
+func (g *LoadGenerator) Start(cpuPercent float64, memoryMB int, duration time.Duration) LoadStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.stopLocked()
+
+	stop := make(chan struct{})
+	g.stop = stop
+	now := time.Now().UTC()
+	endsAt := now.Add(duration)
+	g.status = LoadStatus{
+		Active:     true,
+		CPUPercent: cpuPercent,
+		MemoryMB:   memoryMB,
+		StartedAt:  &now,
+		EndsAt:     &endsAt,
+	}
+
+	var wg sync.WaitGroup
+	if cpuPercent > 0 {
+		busy := time.Duration(cpuPercent / 100 * float64(dutyCyclePeriod))
+		for i := 0; i < runtime.NumCPU(); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				burnCPU(busy, stop)
+			}()
+		}
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		holdMemory(memoryMB, stop)
+	}()
+
+	go func() {
+		select {
+		case <-stop:
+		case <-time.After(duration):
+			g.mu.Lock()
+			if g.stop == stop {
+				g.stopLocked()
+			}
+			g.mu.Unlock()
+		}
+		wg.Wait()
+		debug.FreeOSMemory()
+		log.Printf("Synthetic load finished")
+	}()
+
+	log.Printf("Synthetic load started: %.0f%% CPU, %d MB for %s", cpuPercent, memoryMB, duration)
+	return g.status
+}
+
+func (g *LoadGenerator) Stop() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.stopLocked()
+}
+
+func (g *LoadGenerator) stopLocked() {
+	if g.stop != nil {
+		close(g.stop)
+		g.stop = nil
+	}
+	g.status = LoadStatus{}
+}
+
+func (g *LoadGenerator) Status() LoadStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.status
+}
+
+// burnCPU keeps one core busy for busy out of every dutyCyclePeriod.
+func burnCPU(busy time.Duration, stop <-chan struct{}) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		start := time.Now()
+		for time.Since(start) < busy {
+		}
+		if idle := dutyCyclePeriod - busy; idle > 0 {
+			time.Sleep(idle)
+		}
+	}
+}
+
+// holdMemory allocates memoryMB and writes every page so it is actually
+// resident, then keeps it reachable until stop is closed.
+func holdMemory(memoryMB int, stop <-chan struct{}) {
+	buf := make([]byte, memoryMB<<20)
+	for i := 0; i < len(buf); i += os.Getpagesize() {
+		buf[i] = 1
+	}
+	<-stop
+	runtime.KeepAlive(buf)
+}
+
+func totalMemory() uint64 {
+	vMem, err := mem.VirtualMemory()
+	if err != nil {
+		return 0
+	}
+	return vMem.Total
+}
+
+func (g *LoadGenerator) handler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		jsonReply(w, http.StatusOK, g.Status())
+	case http.MethodDelete:
+		g.Stop()
+		jsonReply(w, http.StatusOK, g.Status())
+	case http.MethodPost:
+		var req LoadRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			jsonReply(w, http.StatusBadRequest, map[string]string{"error": "Invalid JSON: " + err.Error()})
+			return
+		}
+		if req.CPUPercent < 0 || req.CPUPercent > 100 {
+			jsonReply(w, http.StatusBadRequest, map[string]string{"error": "cpu_percent must be between 0 and 100"})
+			return
+		}
+		if req.MemoryMB < 0 || uint64(req.MemoryMB)<<20 > totalMemory()*9/10 {
+			jsonReply(w, http.StatusBadRequest, map[string]string{"error": "memory_mb must be between 0 and 90% of total memory"})
+			return
+		}
+		duration, err := time.ParseDuration(req.Duration)
+		if err != nil || duration <= 0 || duration > maxLoadDuration {
+			jsonReply(w, http.StatusBadRequest, map[string]string{"error": "duration must be a positive duration of at most " + maxLoadDuration.String()})
+			return
+		}
+		jsonReply(w, http.StatusOK, g.Start(req.CPUPercent, req.MemoryMB, duration))
+	default:
+		jsonReply(w, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+}
+
+// requireToken rejects requests that do not carry "Authorization: Bearer <token>".
+// Unlike the server manager, an empty token rejects every request: the load
+// generator and the deploy API are only registered when their token is set.
+func requireToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			jsonReply(w, http.StatusUnauthorized, map[string]string{"error": "Unauthorized"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func jsonReply(w http.ResponseWriter, code int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(payload)
+}
@@ -1,15 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"time"
 
 	"github.com/joho/godotenv"
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/mem"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 type HealthResponse struct {
@@ -18,10 +19,25 @@ type HealthResponse struct {
 }
 
 type MetricsResponse struct {
-	CpuUtilizationPercent    float64 `json:"cpu_utilization_percent"`
-	MemoryUtilizationPercent float64 `json:"memory_utilization_percent"`
-	Status                   string  `json:"status"`
-	Error                    string  `json:"error,omitempty"`
+	CpuUtilizationPercent    float64                 `json:"cpu_utilization_percent"`
+	MemoryUtilizationPercent float64                 `json:"memory_utilization_percent"`
+	CpuCoreUtilization       []float64               `json:"cpu_core_utilization_percent,omitempty"`
+	Load                     *LoadMetrics            `json:"load,omitempty"`
+	Processes                *ProcessMetrics         `json:"processes,omitempty"`
+	Disk                     *DiskMetrics            `json:"disk,omitempty"`
+	Network                  *NetworkMetrics         `json:"network,omitempty"`
+	Pressure                 *PressureMetrics        `json:"pressure,omitempty"`
+	Windows                  map[string]WindowStats  `json:"windows,omitempty"`
+	Custom                   map[string]CustomResult `json:"custom,omitempty"`
+	Status                   string                  `json:"status"`
+	Error                    string                  `json:"error,omitempty"`
+}
+
+type HistoryResponse struct {
+	Interval string   `json:"interval,omitempty"`
+	Window   string   `json:"window,omitempty"`
+	Samples  []Sample `json:"samples,omitempty"`
+	Error    string   `json:"error,omitempty"`
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -33,30 +49,108 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func metricsHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	cpuPercent, err := cpu.Percent(100*time.Millisecond, false)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(MetricsResponse{Error: err.Error()})
-		return
+// currentUtilization is what /metrics reports as the headline CPU and memory
+// utilization: the average over the shortest rolling window, falling back to
+// the latest snapshot before that window has any samples.
+func currentUtilization(snapshot *NodeSnapshot, windows map[string]WindowStats) (float64, float64) {
+	if w := windows[statsWindows[0].Name]; w.Samples > 0 {
+		return w.CPU.Avg, w.Memory.Avg
 	}
+	return snapshot.CPUPercent, snapshot.Memory.UsedPercent
+}
 
-	vMem, err := mem.VirtualMemory()
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(MetricsResponse{Error: err.Error()})
-		return
+func metricsHandler(sampler *Sampler, custom *CustomCollectors) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		snapshot, err := sampler.Latest()
+
+		if wantsPrometheus(r) {
+			if err != nil {
+				writePrometheusError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", prometheusContentType)
+			writePrometheus(w, snapshot, sampler.Windows(), custom.Results())
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(MetricsResponse{Error: err.Error()})
+			return
+		}
+
+		// Encode the response before writing the header so a value that cannot
+		// be encoded is reported as an error instead of an empty 200.
+		data, err := json.Marshal(buildMetricsResponse(snapshot, sampler.Windows(), custom.Results()))
+		if err != nil {
+			log.Printf("Error encoding metrics: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(MetricsResponse{Error: "Failed to encode metrics"})
+			return
+		}
+		w.Write(append(data, '\n'))
 	}
+}
+
+func buildMetricsResponse(snapshot *NodeSnapshot, windows map[string]WindowStats, custom map[string]CustomResult) MetricsResponse {
+	cpuPercent, memPercent := currentUtilization(snapshot, windows)
 
-	response := MetricsResponse{
-		CpuUtilizationPercent:    cpuPercent[0],
-		MemoryUtilizationPercent: vMem.UsedPercent,
+	return MetricsResponse{
+		CpuUtilizationPercent:    cpuPercent,
+		MemoryUtilizationPercent: memPercent,
+		CpuCoreUtilization:       snapshot.CPUCorePercent,
+		Load:                     snapshot.Load,
+		Processes:                snapshot.Procs,
+		Disk:                     snapshot.Disk,
+		Network:                  snapshot.Network,
+		Pressure:                 snapshot.Pressure,
+		Windows:                  windows,
+		Custom:                   custom,
 		Status:                   "active",
 	}
+}
 
-	json.NewEncoder(w).Encode(response)
+func historyHandler(sampler *Sampler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		window := sampler.Retention()
+		if param := r.URL.Query().Get("window"); param != "" {
+			d, err := time.ParseDuration(param)
+			if err != nil || d <= 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(HistoryResponse{Error: "Invalid window. Use a duration such as 30s, 1m or 5m."})
+				return
+			}
+			if d > window {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(HistoryResponse{Error: fmt.Sprintf("Window exceeds history retention of %s.", window)})
+				return
+			}
+			window = d
+		}
+
+		json.NewEncoder(w).Encode(HistoryResponse{
+			Interval: sampler.interval.String(),
+			Window:   window.String(),
+			Samples:  sampler.History(window),
+		})
+	}
+}
+
+func durationEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid %s %q, using %s", key, value, fallback)
+		return fallback
+	}
+	return d
 }
 
 func main() {
@@ -67,11 +161,61 @@ func main() {
 		port = "5100"
 	}
 
+	interval := durationEnv("SAMPLE_INTERVAL", time.Second)
+	retention := durationEnv("HISTORY_RETENTION", 5*time.Minute)
+
+	sampler := NewSampler(interval, retention)
+	go sampler.Run(make(chan struct{}))
+
+	custom, err := LoadCustomCollectors(os.Getenv("COLLECTORS_DIR"))
+	if err != nil {
+		log.Fatalf("Error loading custom collectors: %v", err)
+	}
+	if custom.Len() > 0 {
+		log.Printf("Loaded %d custom collector(s)", custom.Len())
+		custom.Run(make(chan struct{}))
+	}
+
+	shutdownOTel, err := setupOTel(context.Background(), "metrics-api")
+	if err != nil {
+		log.Fatalf("Error setting up OpenTelemetry: %v", err)
+	}
+	shutdownOnSignal(shutdownOTel)
+	if err := registerOTelMetrics(sampler, custom); err != nil {
+		log.Fatalf("Error registering OpenTelemetry metrics: %v", err)
+	}
+
+	docker := NewDockerClient(dockerSocket())
+
+	if pusher := NewPusherFromEnv(sampler, custom); pusher != nil {
+		go pusher.Run(make(chan struct{}))
+	}
+
 	http.HandleFunc("/health", healthHandler)
-	http.HandleFunc("/metrics", metricsHandler)
+	http.HandleFunc("/ready", readyHandler(loadProbes(), docker))
+	http.HandleFunc("/info", infoHandler(docker))
+	http.HandleFunc("/metrics", metricsHandler(sampler, custom))
+	http.HandleFunc("/metrics/history", historyHandler(sampler))
+	http.HandleFunc("/metrics/containers", containersHandler(docker))
+
+	if load := NewLoadGeneratorFromEnv(); load != nil {
+		log.Printf("Synthetic load endpoint enabled at /debug/load")
+		http.HandleFunc("/debug/load", requireToken(load.token, load.handler))
+	}
+
+	if deployer := NewDeployerFromEnv(); deployer != nil {
+		log.Printf("Deploy API enabled, releases in %s", deployer.root)
+		http.HandleFunc("/api/v1/deploy", requireToken(deployer.token, deployer.deployHandler))
+		http.HandleFunc("/api/v1/deploy/releases", requireToken(deployer.token, deployer.releasesHandler))
+	}
 
-	log.Printf("Server starting on port %s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
+	log.Printf("Server starting on port %s (sampling every %s, keeping %s of history)", port, interval, sampler.Retention())
+	handler := otelhttp.NewHandler(http.DefaultServeMux, "metrics-api",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+	)
+	if err := http.ListenAndServe(":"+port, handler); err != nil {
 		log.Fatal(err)
 	}
 }
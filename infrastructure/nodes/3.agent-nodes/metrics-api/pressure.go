@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PressureStall is one line of a /proc/pressure file: the share of wall time
+// (in percent) that tasks were stalled over the last 10, 60 and 300 seconds.
+type PressureStall struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+}
+
+type ResourcePressure struct {
+	Some *PressureStall `json:"some,omitempty"`
+	Full *PressureStall `json:"full,omitempty"`
+}
+
+// PressureMetrics is Linux pressure stall information (PSI). The kernel
+// reports "some" when at least one task is stalled and "full" when all
+// non-idle tasks are stalled at once.
+type PressureMetrics struct {
+	CPU    *ResourcePressure `json:"cpu,omitempty"`
+	Memory *ResourcePressure `json:"memory,omitempty"`
+	IO     *ResourcePressure `json:"io,omitempty"`
+}
+
+// readPressure returns nil without an error when the kernel does not expose PSI.
+func readPressure() (*PressureMetrics, error) {
+	var pressure PressureMetrics
+	found := false
+
+	for _, res := range []struct {
+		name   string
+		target **ResourcePressure
+	}{
+		{"cpu", &pressure.CPU},
+		{"memory", &pressure.Memory},
+		{"io", &pressure.IO},
+	} {
+		rp, err := readPressureFile("/proc/pressure/" + res.name)
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		*res.target = rp
+		found = true
+	}
+
+	if !found {
+		return nil, nil
+	}
+	return &pressure, nil
+}
+
+func readPressureFile(path string) (*ResourcePressure, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rp ResourcePressure
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		stall := &PressureStall{}
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+
+			var target *float64
+			switch key {
+			case "avg10":
+				target = &stall.Avg10
+			case "avg60":
+				target = &stall.Avg60
+			case "avg300":
+				target = &stall.Avg300
+			default:
+				continue
+			}
+
+			if *target, err = strconv.ParseFloat(value, 64); err != nil {
+				return nil, fmt.Errorf("%s: invalid %s: %v", path, key, err)
+			}
+		}
+
+		switch fields[0] {
+		case "some":
+			rp.Some = stall
+		case "full":
+			rp.Full = stall
+		}
+	}
+	return &rp, scanner.Err()
+}
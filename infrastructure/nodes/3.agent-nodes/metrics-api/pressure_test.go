@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadPressureFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		wantSome *PressureStall
+		wantFull *PressureStall
+		wantErr  bool
+	}{
+		{
+			name:     "some and full",
+			content:  "some avg10=1.50 avg60=0.75 avg300=0.10 total=123456\nfull avg10=0.50 avg60=0.25 avg300=0.00 total=65432\n",
+			wantSome: &PressureStall{Avg10: 1.5, Avg60: 0.75, Avg300: 0.1},
+			wantFull: &PressureStall{Avg10: 0.5, Avg60: 0.25},
+		},
+		{
+			// The CPU file only has a "some" line before Linux 5.13.
+			name:     "missing full line",
+			content:  "some avg10=12.00 avg60=8.00 avg300=4.00 total=999\n",
+			wantSome: &PressureStall{Avg10: 12, Avg60: 8, Avg300: 4},
+		},
+		{
+			name:     "unknown fields and blank lines",
+			content:  "\nsome avg10=1.00 avg30=9.00 avg60=2.00 avg300=3.00 bogus\n\n",
+			wantSome: &PressureStall{Avg10: 1, Avg60: 2, Avg300: 3},
+		},
+		{
+			name:    "empty",
+			content: "",
+		},
+		{
+			name:    "invalid average",
+			content: "some avg10=high avg60=0.00 avg300=0.00 total=0\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cpu")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := readPressureFile(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("readPressureFile() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readPressureFile() error = %v", err)
+			}
+			if !equalStall(got.Some, tt.wantSome) {
+				t.Errorf("some = %+v, want %+v", got.Some, tt.wantSome)
+			}
+			if !equalStall(got.Full, tt.wantFull) {
+				t.Errorf("full = %+v, want %+v", got.Full, tt.wantFull)
+			}
+		})
+	}
+
+	if _, err := readPressureFile(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("readPressureFile() of a missing file error = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func equalStall(a, b *PressureStall) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
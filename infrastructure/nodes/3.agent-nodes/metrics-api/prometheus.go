@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// wantsPrometheus reports whether the client asked for the Prometheus text
+// format, either with ?format=prometheus or through the Accept header.
+// JSON stays the default so existing clients keep working.
+func wantsPrometheus(r *http.Request) bool {
+	switch r.URL.Query().Get("format") {
+	case "prometheus":
+		return true
+	case "json":
+		return false
+	}
+
+	accept := r.Header.Get("Accept")
+	if strings.Contains(accept, "application/json") {
+		return false
+	}
+	return strings.Contains(accept, "text/plain") || strings.Contains(accept, "application/openmetrics-text")
+}
+
+type promWriter struct {
+	w io.Writer
+}
+
+func (p promWriter) metric(name, kind, help string) {
+	fmt.Fprintf(p.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func (p promWriter) sample(name, labels string, value float64) {
+	if labels != "" {
+		fmt.Fprintf(p.w, "%s{%s} %g\n", name, labels, value)
+		return
+	}
+	fmt.Fprintf(p.w, "%s %g\n", name, value)
+}
+
+func (p promWriter) gauge(name, help string, value float64) {
+	p.metric(name, "gauge", help)
+	p.sample(name, "", value)
+}
+
+func writePrometheus(w io.Writer, s *NodeSnapshot, windows map[string]WindowStats, custom map[string]CustomResult) {
+	p := promWriter{w: w}
+	cpuPercent, memPercent := currentUtilization(s, windows)
+
+	p.gauge("agent_up", "Whether the metrics API could read node metrics.", 1)
+	p.gauge("agent_cpu_utilization_percent", "Overall CPU utilization in percent, averaged over the shortest window.", cpuPercent)
+	p.gauge("agent_cpu_count", "Number of logical CPUs.", float64(len(s.CPUCorePercent)))
+	p.metric("agent_cpu_core_utilization_percent", "gauge", "CPU utilization per logical core in percent.")
+	for i, v := range s.CPUCorePercent {
+		p.sample("agent_cpu_core_utilization_percent", fmt.Sprintf("core=\"%d\"", i), v)
+	}
+
+	p.gauge("agent_memory_utilization_percent", "Used memory in percent of total, averaged over the shortest window.", memPercent)
+	p.gauge("agent_memory_total_bytes", "Total physical memory in bytes.", float64(s.Memory.Total))
+	p.gauge("agent_memory_available_bytes", "Memory available for new workloads in bytes.", float64(s.Memory.Available))
+	p.gauge("agent_memory_used_bytes", "Used memory in bytes.", float64(s.Memory.Used))
+
+	if s.Load != nil {
+		p.gauge("agent_load1", "1-minute load average.", s.Load.Load1)
+		p.gauge("agent_load5", "5-minute load average.", s.Load.Load5)
+		p.gauge("agent_load15", "15-minute load average.", s.Load.Load15)
+	}
+
+	if s.Procs != nil {
+		p.gauge("agent_processes_total", "Number of processes.", float64(s.Procs.Total))
+		p.gauge("agent_processes_running", "Number of runnable processes.", float64(s.Procs.Running))
+		p.gauge("agent_processes_blocked", "Number of processes blocked on I/O.", float64(s.Procs.Blocked))
+	}
+
+	if s.Disk != nil {
+		labels := fmt.Sprintf("path=%q", s.Disk.Path)
+		p.metric("agent_disk_utilization_percent", "gauge", "Used disk space in percent.")
+		p.sample("agent_disk_utilization_percent", labels, s.Disk.UtilizationPercent)
+		p.metric("agent_disk_total_bytes", "gauge", "Total disk space in bytes.")
+		p.sample("agent_disk_total_bytes", labels, float64(s.Disk.TotalBytes))
+		p.metric("agent_disk_used_bytes", "gauge", "Used disk space in bytes.")
+		p.sample("agent_disk_used_bytes", labels, float64(s.Disk.UsedBytes))
+		p.gauge("agent_disk_read_bytes_per_second", "Bytes read from block devices per second.", s.Disk.ReadBytesPerSec)
+		p.gauge("agent_disk_write_bytes_per_second", "Bytes written to block devices per second.", s.Disk.WriteBytesPerSec)
+		p.gauge("agent_disk_read_ops_per_second", "Completed reads per second.", s.Disk.ReadOpsPerSec)
+		p.gauge("agent_disk_write_ops_per_second", "Completed writes per second.", s.Disk.WriteOpsPerSec)
+	}
+
+	if s.Network != nil {
+		p.gauge("agent_network_receive_bytes_per_second", "Bytes received per second on all non-loopback interfaces.", s.Network.ReceiveBytesPerSec)
+		p.gauge("agent_network_transmit_bytes_per_second", "Bytes sent per second on all non-loopback interfaces.", s.Network.TransmitBytesPerSec)
+		p.gauge("agent_tcp_connections_established", "Number of TCP connections in ESTABLISHED or CLOSE-WAIT state.", float64(s.Network.TCPConnectionsCurrent))
+	}
+
+	if len(s.Interfaces) > 0 {
+		p.metric("agent_network_receive_bytes_total", "counter", "Bytes received per interface.")
+		for _, n := range s.Interfaces {
+			p.sample("agent_network_receive_bytes_total", fmt.Sprintf("interface=%q", n.Name), float64(n.BytesRecv))
+		}
+		p.metric("agent_network_transmit_bytes_total", "counter", "Bytes sent per interface.")
+		for _, n := range s.Interfaces {
+			p.sample("agent_network_transmit_bytes_total", fmt.Sprintf("interface=%q", n.Name), float64(n.BytesSent))
+		}
+	}
+
+	if s.Pressure != nil {
+		writePressure(p, s.Pressure)
+	}
+
+	writeWindows(p, windows)
+	writeCustom(p, custom)
+}
+
+// writeCustom exposes custom collector values as one labelled gauge, so
+// arbitrary metric names never have to be valid Prometheus identifiers.
+func writeCustom(p promWriter, custom map[string]CustomResult) {
+	if len(custom) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(custom))
+	for name := range custom {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	p.metric("agent_custom_up", "gauge", "Whether the last run of a custom collector succeeded.")
+	for _, name := range names {
+		up := 1.0
+		if custom[name].Error != "" {
+			up = 0
+		}
+		p.sample("agent_custom_up", fmt.Sprintf("collector=%q", name), up)
+	}
+
+	p.metric("agent_custom_value", "gauge", "Values reported by custom collectors.")
+	for _, name := range names {
+		metrics := custom[name].Metrics
+		keys := make([]string, 0, len(metrics))
+		for key := range metrics {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			p.sample("agent_custom_value", fmt.Sprintf("collector=%q,metric=%q", name, key), metrics[key])
+		}
+	}
+}
+
+func writeWindows(p promWriter, windows map[string]WindowStats) {
+	for _, series := range []struct {
+		name  string
+		help  string
+		stats func(WindowStats) SeriesStats
+	}{
+		{"agent_cpu_utilization_window_percent", "Rolling CPU utilization statistics in percent.", func(w WindowStats) SeriesStats { return w.CPU }},
+		{"agent_memory_utilization_window_percent", "Rolling memory utilization statistics in percent.", func(w WindowStats) SeriesStats { return w.Memory }},
+	} {
+		p.metric(series.name, "gauge", series.help)
+		for _, window := range statsWindows {
+			ws, ok := windows[window.Name]
+			if !ok || ws.Samples == 0 {
+				continue
+			}
+			st := series.stats(ws)
+			for _, stat := range []struct {
+				name  string
+				value float64
+			}{{"avg", st.Avg}, {"max", st.Max}, {"p50", st.P50}, {"p90", st.P90}, {"p99", st.P99}} {
+				p.sample(series.name, fmt.Sprintf("window=%q,stat=%q", window.Name, stat.name), stat.value)
+			}
+		}
+	}
+}
+
+func writePressure(p promWriter, pressure *PressureMetrics) {
+	type series struct {
+		labels string
+		stall  *PressureStall
+	}
+
+	var all []series
+	for _, res := range []struct {
+		name string
+		rp   *ResourcePressure
+	}{
+		{"cpu", pressure.CPU},
+		{"memory", pressure.Memory},
+		{"io", pressure.IO},
+	} {
+		if res.rp == nil {
+			continue
+		}
+		if res.rp.Some != nil {
+			all = append(all, series{fmt.Sprintf("resource=%q,kind=\"some\"", res.name), res.rp.Some})
+		}
+		if res.rp.Full != nil {
+			all = append(all, series{fmt.Sprintf("resource=%q,kind=\"full\"", res.name), res.rp.Full})
+		}
+	}
+
+	for _, window := range []struct {
+		name  string
+		value func(*PressureStall) float64
+	}{
+		{"avg10", func(s *PressureStall) float64 { return s.Avg10 }},
+		{"avg60", func(s *PressureStall) float64 { return s.Avg60 }},
+		{"avg300", func(s *PressureStall) float64 { return s.Avg300 }},
+	} {
+		name := "agent_pressure_" + window.name + "_percent"
+		p.metric(name, "gauge", "Share of time tasks were stalled on the resource, "+window.name+" window.")
+		for _, s := range all {
+			p.sample(name, s.labels, window.value(s.stall))
+		}
+	}
+}
+
+func writePrometheusError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", prometheusContentType)
+	w.WriteHeader(http.StatusInternalServerError)
+	p := promWriter{w: w}
+	fmt.Fprintf(w, "# metrics collection failed: %s\n", strings.ReplaceAll(err.Error(), "\n", " "))
+	p.gauge("agent_up", "Whether the metrics API could read node metrics.", 0)
+}
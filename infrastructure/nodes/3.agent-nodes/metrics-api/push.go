@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+const signatureHeader = "X-Telemetry-Signature"
+
+// TelemetryPush is the body POSTed to the scaler in push mode.
+type TelemetryPush struct {
+	Agent   string          `json:"agent"`
+	SentAt  time.Time       `json:"sent_at"`
+	Metrics MetricsResponse `json:"metrics"`
+}
+
+// Pusher periodically sends the latest metrics to the scaler's ingest endpoint.
+// Each body is signed with HMAC-SHA256 so the scaler can reject forged or
+// tampered snapshots.
+type Pusher struct {
+	url      string
+	agent    string
+	secret   []byte
+	interval time.Duration
+	sampler  *Sampler
+	custom   *CustomCollectors
+	client   *http.Client
+}
+
+// NewPusherFromEnv returns nil when push mode is not configured (PUSH_URL unset).
+func NewPusherFromEnv(sampler *Sampler, custom *CustomCollectors) *Pusher {
+	url := os.Getenv("PUSH_URL")
+	if url == "" {
+		return nil
+	}
+
+	secret := os.Getenv("PUSH_SECRET")
+	if secret == "" {
+		log.Fatal("PUSH_URL is set but PUSH_SECRET is empty")
+	}
+
+	agent := os.Getenv("AGENT_NAME")
+	if agent == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			log.Fatalf("AGENT_NAME not set and hostname unavailable: %v", err)
+		}
+		agent = hostname
+	}
+
+	return &Pusher{
+		url:      url,
+		agent:    agent,
+		secret:   []byte(secret),
+		interval: durationEnv("PUSH_INTERVAL", 5*time.Second),
+		sampler:  sampler,
+		custom:   custom,
+		client:   &http.Client{Timeout: 5 * time.Second, Transport: otelhttp.NewTransport(http.DefaultTransport)},
+	}
+}
+
+func (p *Pusher) Run(stop <-chan struct{}) {
+	log.Printf("Pushing metrics as %q to %s every %s", p.agent, p.url, p.interval)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := p.push(); err != nil {
+				log.Printf("Error pushing metrics: %v", err)
+			}
+		}
+	}
+}
+
+func (p *Pusher) push() error {
+	snapshot, err := p.sampler.Latest()
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(TelemetryPush{
+		Agent:   p.agent,
+		SentAt:  time.Now().UTC(),
+		Metrics: buildMetricsResponse(snapshot, p.sampler.Windows(), p.custom.Results()),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(signatureHeader, "sha256="+sign(p.secret, body))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("scaler returned status %d: %s", resp.StatusCode, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
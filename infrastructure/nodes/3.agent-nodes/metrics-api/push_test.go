@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// The scaler's pkg/telemetry tests verify the same body and signature, so a
+// change to either side's signing breaks one of the two modules' tests.
+const (
+	testPushSecret    = "shared-secret"
+	testPushBody      = `{"agent":"frodo","sent_at":"2025-01-01T10:00:00Z","metrics":{"cpu_utilization_percent":15.2,"memory_utilization_percent":50,"status":"active"}}`
+	testPushSignature = "9a1b5e8571a53a28e4f48eac0afe91e28081a36dd1eb24cbb81cadb454a3e5e7"
+)
+
+func TestSign(t *testing.T) {
+	if got := sign([]byte(testPushSecret), []byte(testPushBody)); got != testPushSignature {
+		t.Errorf("sign() = %s, want %s", got, testPushSignature)
+	}
+}
+
+func TestPusherSignsBody(t *testing.T) {
+	signatures := make(chan string, 1)
+	bodies := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signatures <- r.Header.Get(signatureHeader)
+		bodies <- body
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	sampler := NewSampler(time.Second, time.Minute)
+	sampler.latest = &NodeSnapshot{CPUPercent: 15.2, Memory: &mem.VirtualMemoryStat{UsedPercent: 50}}
+	p := &Pusher{
+		url:     srv.URL,
+		agent:   "frodo",
+		secret:  []byte(testPushSecret),
+		sampler: sampler,
+		custom:  &CustomCollectors{},
+		client:  srv.Client(),
+	}
+	if err := p.push(); err != nil {
+		t.Fatalf("push() error = %v", err)
+	}
+
+	signature, body := <-signatures, <-bodies
+	if got, want := signature, "sha256="+sign([]byte(testPushSecret), body); got != want {
+		t.Errorf("%s = %s, want %s", signatureHeader, got, want)
+	}
+
+	var push TelemetryPush
+	if err := json.Unmarshal(body, &push); err != nil {
+		t.Fatalf("pushed body is not JSON: %v", err)
+	}
+	if push.Agent != "frodo" || push.Metrics.CpuUtilizationPercent != 15.2 || time.Since(push.SentAt) > time.Minute {
+		t.Errorf("pushed %+v, want frodo at 15.2%% CPU sent just now", push)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Probe describes one readiness check. Type selects which of the remaining
+// fields are used:
+//   - "http":    GET URL and expect ExpectStatus (default: any 2xx)
+//   - "tcp":     connect to Address
+//   - "compose": at least MinRunning containers of Project/Service are
+//     running and none of them reports an unhealthy health check
+type Probe struct {
+	Name         string `json:"name"`
+	Type         string `json:"type"`
+	URL          string `json:"url,omitempty"`
+	ExpectStatus int    `json:"expect_status,omitempty"`
+	Address      string `json:"address,omitempty"`
+	Project      string `json:"project,omitempty"`
+	Service      string `json:"service,omitempty"`
+	MinRunning   int    `json:"min_running,omitempty"`
+	Timeout      string `json:"timeout,omitempty"`
+}
+
+type ProbeResult struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Target     string `json:"target"`
+	OK         bool   `json:"ok"`
+	DurationMs int64  `json:"duration_ms"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+type ReadyResponse struct {
+	Status string        `json:"status"`
+	Probes []ProbeResult `json:"probes"`
+}
+
+// defaultProbes match the pluggable API compose stack deployed by the scaler.
+var defaultProbes = []Probe{
+	{Name: "app-http", Type: "http", URL: "http://127.0.0.1:5000/health"},
+	{Name: "server-container", Type: "compose", Project: "compose", Service: "async-fibonacci-server"},
+	{Name: "worker-container", Type: "compose", Project: "compose", Service: "async-fibonacci-worker"},
+}
+
+func loadProbes() []Probe {
+	probesJSON := os.Getenv("READY_PROBES")
+	if probesJSON == "" {
+		return defaultProbes
+	}
+
+	var probes []Probe
+	if err := json.Unmarshal([]byte(probesJSON), &probes); err != nil {
+		log.Fatalf("Error parsing READY_PROBES environment variable: %v", err)
+	}
+	for i, p := range probes {
+		if err := p.validate(); err != nil {
+			log.Fatalf("Invalid READY_PROBES entry %d: %v", i, err)
+		}
+	}
+	return probes
+}
+
+func (p Probe) validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("missing name")
+	}
+	if _, err := p.timeout(); err != nil {
+		return fmt.Errorf("probe %s: invalid timeout %q", p.Name, p.Timeout)
+	}
+	switch p.Type {
+	case "http":
+		if p.URL == "" {
+			return fmt.Errorf("probe %s: http probe requires url", p.Name)
+		}
+	case "tcp":
+		if p.Address == "" {
+			return fmt.Errorf("probe %s: tcp probe requires address", p.Name)
+		}
+	case "compose":
+		if p.Project == "" || p.Service == "" {
+			return fmt.Errorf("probe %s: compose probe requires project and service", p.Name)
+		}
+	default:
+		return fmt.Errorf("probe %s: unknown type %q", p.Name, p.Type)
+	}
+	return nil
+}
+
+func (p Probe) timeout() (time.Duration, error) {
+	if p.Timeout == "" {
+		return 2 * time.Second, nil
+	}
+	return time.ParseDuration(p.Timeout)
+}
+
+func (p Probe) target() string {
+	switch p.Type {
+	case "http":
+		return p.URL
+	case "tcp":
+		return p.Address
+	case "compose":
+		return p.Project + "/" + p.Service
+	}
+	return ""
+}
+
+func (p Probe) Run(ctx context.Context, docker *DockerClient) ProbeResult {
+	timeout, _ := p.timeout()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	var err error
+	switch p.Type {
+	case "http":
+		err = p.checkHTTP(ctx)
+	case "tcp":
+		err = p.checkTCP(ctx)
+	case "compose":
+		err = p.checkCompose(ctx, docker)
+	}
+
+	result := ProbeResult{
+		Name:       p.Name,
+		Type:       p.Type,
+		Target:     p.target(),
+		OK:         err == nil,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result.Detail = err.Error()
+	}
+	return result
+}
+
+func (p Probe) checkHTTP(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if p.ExpectStatus != 0 {
+		if resp.StatusCode != p.ExpectStatus {
+			return fmt.Errorf("status %d, expected %d", resp.StatusCode, p.ExpectStatus)
+		}
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (p Probe) checkTCP(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.Address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (p Probe) checkCompose(ctx context.Context, docker *DockerClient) error {
+	containers, err := docker.ListContainers(ctx, true)
+	if err != nil {
+		return err
+	}
+
+	minRunning := p.MinRunning
+	if minRunning < 1 {
+		minRunning = 1
+	}
+
+	running := 0
+	for _, c := range containers {
+		if c.Labels[composeProjectLabel] != p.Project || c.Labels[composeServiceLabel] != p.Service {
+			continue
+		}
+		if c.State != "running" {
+			continue
+		}
+		inspect, err := docker.Inspect(ctx, c.ID)
+		if err != nil {
+			return err
+		}
+		if inspect.State.Health != nil && inspect.State.Health.Status != "healthy" {
+			return fmt.Errorf("container %s is %s", c.Name(), inspect.State.Health.Status)
+		}
+		running++
+	}
+
+	if running < minRunning {
+		return fmt.Errorf("%d running container(s), expected at least %d", running, minRunning)
+	}
+	return nil
+}
+
+func readyHandler(probes []Probe, docker *DockerClient) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		results := make([]ProbeResult, len(probes))
+
+		var wg sync.WaitGroup
+		for i, p := range probes {
+			wg.Add(1)
+			go func(i int, p Probe) {
+				defer wg.Done()
+				results[i] = p.Run(r.Context(), docker)
+			}(i, p)
+		}
+		wg.Wait()
+
+		response := ReadyResponse{Status: "ready", Probes: results}
+		code := http.StatusOK
+		for _, res := range results {
+			if !res.OK {
+				response.Status = "not_ready"
+				code = http.StatusServiceUnavailable
+				break
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(response)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Sample is the part of a snapshot kept in the history ring buffer.
+type Sample struct {
+	Time                     time.Time `json:"time"`
+	CpuUtilizationPercent    float64   `json:"cpu_utilization_percent"`
+	MemoryUtilizationPercent float64   `json:"memory_utilization_percent"`
+	Load1                    float64   `json:"load1"`
+}
+
+type SeriesStats struct {
+	Avg float64 `json:"avg"`
+	Max float64 `json:"max"`
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+type WindowStats struct {
+	Samples int         `json:"samples"`
+	CPU     SeriesStats `json:"cpu_utilization_percent"`
+	Memory  SeriesStats `json:"memory_utilization_percent"`
+}
+
+// statsWindows are the rolling windows reported by /metrics, shortest first.
+var statsWindows = []struct {
+	Name     string
+	Duration time.Duration
+}{
+	{"10s", 10 * time.Second},
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+}
+
+var errNoSamples = errors.New("no metrics sampled yet")
+
+// Sampler collects a NodeSnapshot every interval in the background and keeps
+// the resulting samples in a fixed-size ring buffer covering the retention period.
+type Sampler struct {
+	interval  time.Duration
+	collector Collector
+
+	mu      sync.RWMutex
+	ring    []Sample
+	next    int
+	count   int
+	latest  *NodeSnapshot
+	lastErr error
+}
+
+func NewSampler(interval, retention time.Duration) *Sampler {
+	size := int(retention / interval)
+	if size < 1 {
+		size = 1
+	}
+	return &Sampler{
+		interval: interval,
+		ring:     make([]Sample, size),
+	}
+}
+
+// Run samples until stop is closed. The first sample is taken immediately.
+func (s *Sampler) Run(stop <-chan struct{}) {
+	s.sample()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.sample()
+		}
+	}
+}
+
+func (s *Sampler) sample() {
+	snapshot, err := s.collector.Collect()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Error sampling metrics: %v", err)
+		s.lastErr = err
+		return
+	}
+
+	s.lastErr = nil
+	s.latest = snapshot
+	sample := Sample{
+		Time:                     time.Now().UTC(),
+		CpuUtilizationPercent:    snapshot.CPUPercent,
+		MemoryUtilizationPercent: snapshot.Memory.UsedPercent,
+	}
+	if snapshot.Load != nil {
+		sample.Load1 = snapshot.Load.Load1
+	}
+	s.record(sample)
+}
+
+// record adds sample to the ring buffer, overwriting the oldest one once it
+// is full. The caller must hold s.mu.
+func (s *Sampler) record(sample Sample) {
+	s.ring[s.next] = sample
+	s.next = (s.next + 1) % len(s.ring)
+	if s.count < len(s.ring) {
+		s.count++
+	}
+}
+
+// Latest returns the most recent snapshot, or the error of the most recent
+// sampling attempt if it failed.
+func (s *Sampler) Latest() (*NodeSnapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.lastErr != nil {
+		return nil, s.lastErr
+	}
+	if s.latest == nil {
+		return nil, errNoSamples
+	}
+	return s.latest, nil
+}
+
+// History returns the samples taken within window, oldest first.
+func (s *Sampler) History(window time.Duration) []Sample {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	cutoff := time.Now().Add(-window)
+	samples := []Sample{}
+	for i := 0; i < s.count; i++ {
+		sample := s.ring[(s.next-s.count+i+len(s.ring))%len(s.ring)]
+		if sample.Time.After(cutoff) {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+func (s *Sampler) Retention() time.Duration {
+	return s.interval * time.Duration(len(s.ring))
+}
+
+// Windows summarises the history over each of statsWindows.
+func (s *Sampler) Windows() map[string]WindowStats {
+	windows := make(map[string]WindowStats, len(statsWindows))
+	for _, w := range statsWindows {
+		samples := s.History(w.Duration)
+
+		cpu := make([]float64, len(samples))
+		memory := make([]float64, len(samples))
+		for i, sample := range samples {
+			cpu[i] = sample.CpuUtilizationPercent
+			memory[i] = sample.MemoryUtilizationPercent
+		}
+
+		windows[w.Name] = WindowStats{
+			Samples: len(samples),
+			CPU:     seriesStats(cpu),
+			Memory:  seriesStats(memory),
+		}
+	}
+	return windows
+}
+
+func seriesStats(values []float64) SeriesStats {
+	if len(values) == 0 {
+		return SeriesStats{}
+	}
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	return SeriesStats{
+		Avg: average(sorted),
+		Max: sorted[len(sorted)-1],
+		P50: percentile(sorted, 50),
+		P90: percentile(sorted, 90),
+		P99: percentile(sorted, 99),
+	}
+}
+
+// percentile uses the nearest-rank method on already sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestSeriesStats(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   SeriesStats
+	}{
+		{"empty", nil, SeriesStats{}},
+		{"single sample", []float64{42}, SeriesStats{Avg: 42, Max: 42, P50: 42, P90: 42, P99: 42}},
+		{"two samples", []float64{80, 20}, SeriesStats{Avg: 50, Max: 80, P50: 20, P90: 80, P99: 80}},
+		{
+			"ten samples unsorted",
+			[]float64{100, 10, 90, 20, 80, 30, 70, 40, 60, 50},
+			SeriesStats{Avg: 55, Max: 100, P50: 50, P90: 90, P99: 100},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values := slices.Clone(tt.values)
+			if got := seriesStats(values); got != tt.want {
+				t.Errorf("seriesStats(%v) = %+v, want %+v", tt.values, got, tt.want)
+			}
+			if !slices.Equal(values, tt.values) {
+				t.Errorf("seriesStats() reordered its input to %v", values)
+			}
+		})
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []float64{1, 2, 3, 4, 5}
+	tests := []struct {
+		p    float64
+		want float64
+	}{
+		{0, 1},
+		{1, 1},
+		{20, 1},
+		{21, 2},
+		{50, 3},
+		{99, 5},
+		{100, 5},
+	}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(%v, %v) = %v, want %v", sorted, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestSamplerHistory(t *testing.T) {
+	now := time.Now().UTC()
+	at := func(ago time.Duration, cpu float64) Sample {
+		return Sample{Time: now.Add(-ago), CpuUtilizationPercent: cpu}
+	}
+
+	tests := []struct {
+		name    string
+		samples []Sample
+		window  time.Duration
+		want    []float64
+	}{
+		{"empty", nil, time.Minute, []float64{}},
+		{"single sample", []Sample{at(time.Second, 10)}, time.Minute, []float64{10}},
+		{
+			"partially filled",
+			[]Sample{at(3*time.Second, 10), at(2*time.Second, 20)},
+			time.Minute,
+			[]float64{10, 20},
+		},
+		{
+			// Six samples in a ring of four: the two oldest are overwritten
+			// and the rest come back oldest first across the wrap.
+			"wraparound",
+			[]Sample{at(6*time.Second, 10), at(5*time.Second, 20), at(4*time.Second, 30), at(3*time.Second, 40), at(2*time.Second, 50), at(time.Second, 60)},
+			time.Minute,
+			[]float64{30, 40, 50, 60},
+		},
+		{
+			"window excludes older samples",
+			[]Sample{at(30*time.Second, 10), at(20*time.Second, 20), at(5*time.Second, 30)},
+			10 * time.Second,
+			[]float64{30},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSampler(time.Second, 4*time.Second)
+			for _, sample := range tt.samples {
+				s.record(sample)
+			}
+
+			history := s.History(tt.window)
+			got := make([]float64, len(history))
+			for i, sample := range history {
+				got[i] = sample.CpuUtilizationPercent
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("History(%s) = %v, want %v", tt.window, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSamplerWindowsWithoutSamples(t *testing.T) {
+	s := NewSampler(time.Second, time.Minute)
+	for name, stats := range s.Windows() {
+		if stats != (WindowStats{}) {
+			t.Errorf("window %s = %+v without samples, want zero stats", name, stats)
+		}
+	}
+	if _, err := s.Latest(); err != errNoSamples {
+		t.Errorf("Latest() error = %v, want %v", err, errNoSamples)
+	}
+}